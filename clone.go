@@ -0,0 +1,471 @@
+package goloose
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// CloneOptions control how CloneOpts copies a value.
+type CloneOptions struct {
+	AllFields        bool // copy every field, including unexported ones and ones JSON ignores, instead of only the ones ConvertTo would
+	PreserveAliasing bool // pointers and maps that are the same in the value are the same in the copy, and slices that share a backing array share one in the copy, which also lets the value have cycles
+
+	Options Options // decides which fields JSON sees and converts the values that convert themselves, like for ConvertTo
+}
+
+// Clone returns a deep copy of v: the same as ConvertTo[T](v), but without matching up fields, since
+// they're the same on both sides. Slices and maps are never shared with v. See CloneOpts for the details.
+// It panics if CloneOpts would return an error, which only happens if v has a cycle, is nested more
+// deeply than Options.MaxDepth allows by default, or holds a value that fails to convert itself.
+func Clone[T any](v T) T {
+	c, err := CloneOpts(v, CloneOptions{})
+	if err != nil {
+		panic(fmt.Sprintf("goloose: can't clone %T: %v", v, err))
+	}
+	return c
+}
+
+// CloneOpts returns a deep copy of v. By default only the fields that JSON sees are copied, with the
+// result ConvertTo[T](v, opts.Options) would give, except that interfaces hold copies of what they held
+// before rather than the maps and float64s JSON would turn them into, and values ConvertTo can't convert,
+// like NaNs and complex numbers, are copied as they are. Values whose types convert themselves, like with MarshalJSON or
+// a converter, are still converted.
+//
+// With opts.AllFields, every field is copied, including unexported ones, and no methods are called.
+// That's only safe for types that don't mind being copied. Either way, channels, funcs, unsafe pointers
+// and *time.Locations are shared with v, since they can't or don't need to be copied.
+func CloneOpts[T any](v T, opts CloneOptions) (T, error) {
+	var out T
+	if err := opts.Options.validate(); err != nil {
+		return out, err
+	}
+	c := cloner{opts: opts}
+	if opts.PreserveAliasing {
+		c.copies = map[cycleKey]reflect.Value{}
+		c.arrays = map[cycleKey]*sharedArray{}
+	}
+	// reflect.ValueOf(&v) makes v addressable, so that AllFields can reach its unexported fields
+	src := reflect.ValueOf(&v).Elem()
+	err := c.clone(reflect.ValueOf(&out).Elem(), src, cachedClonePlan(src.Type(), opts), recursion{})
+	return out, unwrapSkipValError(err)
+}
+
+type cloner struct {
+	opts   CloneOptions
+	copies map[cycleKey]reflect.Value // for PreserveAliasing, the copies of the pointers and maps seen so far
+	arrays map[cycleKey]*sharedArray  // for PreserveAliasing, the copies of slices' backing arrays, by their last elements
+}
+
+// A sharedArray is the copy of a backing array that the copies of the slices into it share, for PreserveAliasing.
+type sharedArray struct {
+	copy   reflect.Value // a slice of the whole copy, from where the slice that made it starts
+	cloned []bool        // which of copy's elements have been filled in, since only the ones in slices are
+}
+
+// clone makes dst, a zero value of src's type, a deep copy of src. cp is the clonePlan for that type.
+func (c *cloner) clone(dst, src reflect.Value, cp *clonePlan, rec recursion) error {
+	options := c.opts.Options
+	if rec.level > options.maxDepth() {
+		return ErrMaxDepth
+	}
+	t := src.Type()
+	if len(options.Converters) > 0 && !c.opts.AllFields {
+		if handled, err := convertWithConverter(src, dst, options); handled {
+			return err
+		}
+	}
+	switch {
+	case cp.plain:
+		dst.Set(src)
+		return nil
+	case cp.custom:
+		return toStructImpl(src, dst, options, rec)
+	case t == locationPtrType:
+		dst.Set(src)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if src.IsNil() {
+			if options.Semantics == SemanticsV2 && !c.opts.AllFields && t.Kind() != reflect.Ptr {
+				// v2 encodes nil maps and slices as {} and [], so ConvertTo gives empty ones
+				c.makeEmpty(dst, src)
+			}
+			return nil
+		}
+		if c.copies != nil && t.Kind() == reflect.Slice {
+			return c.cloneSharedSlice(dst, src, t, rec)
+		}
+		if c.copies != nil {
+			key := cycleKey{typ: t, ptr: src.UnsafePointer()}
+			if copied, ok := c.copies[key]; ok {
+				dst.Set(copied)
+				return nil
+			}
+			// record the copy before filling it in, in case src refers back to itself
+			c.copies[key] = c.makeEmpty(dst, src)
+			return c.cloneContents(dst, src, t, rec)
+		}
+		if rec.seen != nil {
+			key, err := rec.visit(src, t)
+			if err != nil {
+				return err
+			}
+			c.makeEmpty(dst, src)
+			err = c.cloneContents(dst, src, t, rec)
+			delete(rec.seen, key)
+			return err
+		}
+		c.makeEmpty(dst, src)
+		return c.cloneContents(dst, src, t, rec)
+	case reflect.Interface:
+		if src.IsNil() {
+			return nil
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		err := c.clone(elem, src.Elem(), cachedClonePlan(elem.Type(), c.opts), rec.next())
+		dst.Set(elem)
+		return err
+	case reflect.Array:
+		var savedErrs []error
+		for i := 0; i < src.Len(); i++ {
+			err := c.clone(dst.Index(i), src.Index(i), cp.children[0], rec.next())
+			if err := saveError(&savedErrs, options, addErrorContext(err, nil, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return joinErrors(savedErrs)
+	case reflect.Struct:
+		if c.opts.AllFields {
+			return c.cloneAllFields(dst, src, t, cp, rec)
+		}
+		return c.cloneFields(dst, src, t, cp, rec)
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		// only AllFields gets here, these can only be shared
+		dst.Set(src)
+	}
+	return nil
+}
+
+// cloneSharedSlice makes dst a copy of the slice src that shares its backing array with the copies of the
+// other slices of src's backing array, for PreserveAliasing. Slices that can hold more elements than one that
+// was copied earlier, because they start earlier in the backing array, get a new one, since the earlier one
+// isn't big enough.
+func (c *cloner) cloneSharedSlice(dst, src reflect.Value, t reflect.Type, rec recursion) error {
+	size := t.Elem().Size()
+	if src.Cap() == 0 || size == 0 {
+		// there's nothing to share
+		c.makeEmpty(dst, src)
+		return c.cloneContents(dst, src, t, rec)
+	}
+	// slices of the same backing array end at the same place, unless they were sliced with a capacity,
+	// so they have the same last element
+	key := cycleKey{typ: t, ptr: unsafe.Add(src.UnsafePointer(), uintptr(src.Cap()-1)*size)}
+	arr := c.arrays[key]
+	if arr == nil || arr.copy.Len() < src.Cap() {
+		arr = &sharedArray{copy: reflect.MakeSlice(t, src.Cap(), src.Cap()), cloned: make([]bool, src.Cap())}
+		c.arrays[key] = arr
+	}
+	start := arr.copy.Len() - src.Cap()
+	dst.Set(arr.copy.Slice(start, start+src.Len()))
+	elemPlan := cachedClonePlan(t.Elem(), c.opts)
+	var savedErrs []error
+	for i := 0; i < src.Len(); i++ {
+		if arr.cloned[start+i] {
+			continue
+		}
+		// mark it first, in case the element refers back to the slice
+		arr.cloned[start+i] = true
+		err := c.clone(dst.Index(i), src.Index(i), elemPlan, rec.next())
+		if err := saveError(&savedErrs, c.opts.Options, addErrorContext(err, nil, strconv.Itoa(i))); err != nil {
+			return err
+		}
+	}
+	return joinErrors(savedErrs)
+}
+
+// makeEmpty sets dst to a new pointer, map or slice the size of src, for cloneContents to fill in, and returns it.
+func (c *cloner) makeEmpty(dst, src reflect.Value) reflect.Value {
+	var v reflect.Value
+	switch t := dst.Type(); t.Kind() {
+	case reflect.Ptr:
+		v = reflect.New(t.Elem())
+	case reflect.Map:
+		v = reflect.MakeMapWithSize(t, src.Len())
+	case reflect.Slice:
+		v = reflect.MakeSlice(t, src.Len(), src.Len())
+	}
+	dst.Set(v)
+	return v
+}
+
+// cloneContents copies what the pointer, map or slice src holds into dst, which makeEmpty made.
+func (c *cloner) cloneContents(dst, src reflect.Value, t reflect.Type, rec recursion) error {
+	options := c.opts.Options
+	switch t.Kind() {
+	case reflect.Ptr:
+		return c.clone(dst.Elem(), src.Elem(), cachedClonePlan(t.Elem(), c.opts), rec.next())
+	case reflect.Map:
+		var savedErrs []error
+		keyPlan, elemPlan := cachedClonePlan(t.Key(), c.opts), cachedClonePlan(t.Elem(), c.opts)
+		iter := src.MapRange()
+		for iter.Next() {
+			key, val := iter.Key(), iter.Value()
+			if !keyPlan.plain {
+				newKey := reflect.New(t.Key()).Elem()
+				if err := c.clone(newKey, key, keyPlan, rec.next()); err != nil {
+					if err := saveError(&savedErrs, options, addErrorContext(err, nil, fmt.Sprint(key))); err != nil {
+						return err
+					}
+					continue
+				}
+				key = newKey
+			}
+			if !elemPlan.plain {
+				newVal := reflect.New(t.Elem()).Elem()
+				err := c.clone(newVal, val, elemPlan, rec.next())
+				var skipErr *skipValError
+				skipped := errors.As(err, &skipErr)
+				if err := saveError(&savedErrs, options, addErrorContext(err, nil, fmt.Sprint(key))); err != nil {
+					return err
+				}
+				if skipped {
+					// like toStructImpl, leave the entry out
+					continue
+				}
+				val = newVal
+			}
+			dst.SetMapIndex(key, val)
+		}
+		return joinErrors(savedErrs)
+	case reflect.Slice:
+		elemPlan := cachedClonePlan(t.Elem(), c.opts)
+		if elemPlan.plain {
+			reflect.Copy(dst, src)
+			return nil
+		}
+		var savedErrs []error
+		for i := 0; i < src.Len(); i++ {
+			err := c.clone(dst.Index(i), src.Index(i), elemPlan, rec.next())
+			if err := saveError(&savedErrs, options, addErrorContext(err, nil, strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return joinErrors(savedErrs)
+	}
+	return nil
+}
+
+// cloneFields copies the fields of the struct src that JSON sees into dst, the way toStructImpl would.
+func (c *cloner) cloneFields(dst, src reflect.Value, t reflect.Type, cp *clonePlan, rec recursion) error {
+	options := c.opts.Options
+	v2 := options.Semantics == SemanticsV2
+	var savedErrs []error
+	for i := range cp.fields {
+		field := &cp.fields[i]
+		val, ok := fieldByIndexNoAlloc(src, field.index)
+		if !ok {
+			// like json.Marshal, skip fields of nil embedded structs
+			continue
+		}
+		if field.omitEmpty && (!v2 && isEmptyValue(val) || v2 && isEmptyValueV2(val, options)) {
+			continue
+		}
+		if field.omitZero && (field.isZero == nil && val.IsZero() || field.isZero != nil && field.isZero(val)) {
+			continue
+		}
+		if val.Kind() == reflect.Ptr && val.IsNil() {
+			continue
+		}
+		err := c.clone(fieldByIndex(dst, field.index, true), val, cp.children[i], rec.next())
+		if err := saveError(&savedErrs, options, addErrorContext(err, t, field.name)); err != nil {
+			return err
+		}
+	}
+	return joinErrors(savedErrs)
+}
+
+// cloneAllFields copies every field of the struct src into dst, for CloneOptions.AllFields.
+func (c *cloner) cloneAllFields(dst, src reflect.Value, t reflect.Type, cp *clonePlan, rec recursion) error {
+	if !src.CanAddr() {
+		// unexported fields can only be reached through their addresses
+		addressable := reflect.New(t).Elem()
+		addressable.Set(src)
+		src = addressable
+	}
+	var savedErrs []error
+	for i := 0; i < t.NumField(); i++ {
+		dstField, srcField := dst.Field(i), src.Field(i)
+		if !t.Field(i).IsExported() {
+			dstField = reflect.NewAt(dstField.Type(), unsafe.Pointer(dstField.UnsafeAddr())).Elem()
+			srcField = reflect.NewAt(srcField.Type(), unsafe.Pointer(srcField.UnsafeAddr())).Elem()
+		}
+		err := c.clone(dstField, srcField, cp.children[i], rec.next())
+		if err := saveError(&savedErrs, c.opts.Options, addErrorContext(err, t, t.Field(i).Name)); err != nil {
+			return err
+		}
+	}
+	return joinErrors(savedErrs)
+}
+
+var locationPtrType = reflect.TypeFor[*time.Location]()
+
+// A clonePlan records what cloner.clone does with values of a type.
+type clonePlan struct {
+	plain    bool         // assigning the value copies it, since there's nothing in it to share, and nothing to leave out
+	custom   bool         // the value converts itself, so toStructImpl has to copy it
+	fields   []field      // for a struct, the fields JSON sees
+	children []*clonePlan // for a struct, the plans for fields, or for every field with AllFields; for an array, its element's plan
+}
+
+type clonePlanKey struct {
+	typ        reflect.Type
+	allFields  bool
+	tagNames   string // like fieldCacheKey
+	nameMapper NameMapper
+	semantics  Semantics
+}
+
+var clonePlanCache struct {
+	value atomic.Value // map[clonePlanKey]*clonePlan
+	mu    sync.Mutex   // used only by writers
+}
+
+// cachedClonePlan returns the clonePlan for t with opts, making it if needed.
+func cachedClonePlan(t reflect.Type, opts CloneOptions) *clonePlan {
+	options := opts.Options
+	if options.NameMapper != nil && !reflect.TypeOf(options.NameMapper).Comparable() && !opts.AllFields {
+		// this can't be a map key
+		return newClonePlan(t, opts)
+	}
+	key := clonePlanKey{typ: t, allFields: opts.AllFields}
+	if !opts.AllFields {
+		key.tagNames = strings.Join(options.TagNames, " ")
+		key.nameMapper = options.NameMapper
+		key.semantics = options.Semantics
+	}
+	m, _ := clonePlanCache.value.Load().(map[clonePlanKey]*clonePlan)
+	if cp, ok := m[key]; ok {
+		return cp
+	}
+
+	// Like cachedPlan, make the plan without the lock.
+	cp := newClonePlan(t, opts)
+
+	clonePlanCache.mu.Lock()
+	m, _ = clonePlanCache.value.Load().(map[clonePlanKey]*clonePlan)
+	newM := make(map[clonePlanKey]*clonePlan, len(m)+1)
+	for k, v := range m {
+		newM[k] = v
+	}
+	newM[key] = cp
+	clonePlanCache.value.Store(newM)
+	clonePlanCache.mu.Unlock()
+	return cp
+}
+
+func newClonePlan(t reflect.Type, opts CloneOptions) *clonePlan {
+	cp := &clonePlan{}
+	if opts.AllFields {
+		cp.plain = pointerFree(t)
+		switch {
+		case cp.plain:
+		case t.Kind() == reflect.Array:
+			cp.children = []*clonePlan{cachedClonePlan(t.Elem(), opts)}
+		case t.Kind() == reflect.Struct:
+			for i := 0; i < t.NumField(); i++ {
+				cp.children = append(cp.children, cachedClonePlan(t.Field(i).Type, opts))
+			}
+		}
+		return cp
+	}
+
+	if t == timeType && !cachedPlan(t, t, opts.Options).converter {
+		// like timeFastPath, a copy is as good as a round trip through JSON
+		cp.plain = true
+		return cp
+	}
+	if t.Kind() != reflect.Interface {
+		// what an interface holds gets its own plan
+		p := cachedPlan(t, t, opts.Options)
+		cp.custom = p.converter || p.goloose || p.customJson
+	}
+	if cp.custom {
+		return cp
+	}
+
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.String:
+		cp.plain = true
+	case reflect.Complex64, reflect.Complex128, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		// JSON can't encode these, so like NaNs they're copied as they are
+		cp.plain = true
+	case reflect.Array:
+		cp.children = []*clonePlan{cachedClonePlan(t.Elem(), opts)}
+		cp.plain = cp.children[0].plain
+	case reflect.Struct:
+		cp.fields = cachedTypeFields(t, opts.Options).list
+		cp.plain = coversStruct(t, nil, cp.fields)
+		for i := range cp.fields {
+			// field.typ follows pointers, which would go around in circles for recursive types
+			cp.children = append(cp.children, cachedClonePlan(t.FieldByIndex(cp.fields[i].index).Type, opts))
+			// leaving out an empty field of one of these leaves it zero, which is the same as copying it
+			cp.plain = cp.plain && cp.children[i].plain
+		}
+	}
+	return cp
+}
+
+// pointerFree reports whether values of type t have nothing in them that a copy could share, apart from
+// *time.Locations, which never change.
+func pointerFree(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		return true
+	case reflect.Array:
+		return pointerFree(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !pointerFree(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return t == locationPtrType
+}
+
+// coversStruct reports whether fields, the fields JSON sees, include every field of the struct t, or of
+// the structs embedded in it, so that nothing is left out. index is t's index within the outer struct.
+func coversStruct(t reflect.Type, index []int, fields []field) bool {
+	for i := 0; i < t.NumField(); i++ {
+		fieldIndex := append(index[:len(index):len(index)], i)
+		found := false
+		for j := range fields {
+			if slices.Equal(fields[j].index, fieldIndex) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		if f := t.Field(i); !f.Anonymous || f.Type.Kind() != reflect.Struct || !coversStruct(f.Type, fieldIndex, fields) {
+			return false
+		}
+	}
+	return true
+}
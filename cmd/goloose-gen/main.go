@@ -0,0 +1,278 @@
+// Command goloose-gen writes functions that convert between the types of a package the same way
+// goloose.ToStruct does, without the cost of reflection. Run it from the package's directory,
+// usually with a go:generate comment:
+//
+//	//go:generate go run github.com/reillywatson/goloose/cmd/goloose-gen -o convert_gen.go UserToDTO=User:UserDTO Order:OrderDTO
+//
+// Each argument is a pair of exported types, In:Out, optionally preceded by the name of the function
+// to generate, which defaults to InToOut. The functions look like
+//
+//	func UserToDTO(in *User, out *UserDTO) error
+//
+// See goloose.Generate for what they convert themselves and what they leave to goloose.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type pair struct {
+	name, in, out string
+}
+
+var (
+	output   = flag.String("o", "goloose_gen.go", "the file to write")
+	tagNames = flag.String("tagnames", "", "comma-separated Options.TagNames")
+	names    = flag.String("names", "", "Options.NameMapper: snake, kebab, camel or screaming-snake")
+
+	caseSensitive         = flag.Bool("case-sensitive", false, "set Options.CaseSensitive")
+	disallowUnknownFields = flag.Bool("disallow-unknown-fields", false, "set Options.DisallowUnknownFields")
+	collectErrors         = flag.Bool("collect-errors", false, "set Options.CollectErrors")
+	useNumber             = flag.Bool("use-number", false, "set Options.UseNumber")
+	preserveIntegers      = flag.Bool("preserve-integers", false, "set Options.PreserveIntegers")
+	stringToFloat64       = flag.Bool("string-to-float64", false, "set Options.StringToFloat64")
+	reset                 = flag.Bool("reset", false, "set Options.Reset")
+	maxDepth              = flag.Int("max-depth", 0, "Options.MaxDepth")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: goloose-gen [flags] [Name=]In:Out...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "goloose-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	pairs, err := parsePairs(args)
+	if err != nil {
+		return err
+	}
+	options, err := optionsLiteral()
+	if err != nil {
+		return err
+	}
+	list, err := exec.Command("go", "list", "-f", "{{.ImportPath}} {{.Name}}", ".").Output()
+	if err != nil {
+		return fmt.Errorf("finding the package: %w", commandError(err))
+	}
+	pkgPath, pkgName, _ := strings.Cut(strings.TrimSpace(string(list)), " ")
+	if pkgName == "main" {
+		return errors.New("can't generate code for package main, since it can't be imported")
+	}
+
+	// The package has to build without the old output, which might not match the types any more,
+	// but code in the package might call the functions already, so the build sees a stub instead.
+	path, err := filepath.Abs(*output)
+	if err != nil {
+		return err
+	}
+	overlay, err := writeOverlay(path, stub(pkgName, pairs))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(filepath.Dir(overlay))
+	src, err := generate(pkgPath, pkgName, pairs, options, overlay)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, src)
+}
+
+func parsePairs(args []string) ([]pair, error) {
+	var pairs []pair
+	for _, arg := range args {
+		var p pair
+		name, types, ok := strings.Cut(arg, "=")
+		if !ok {
+			name, types = "", arg
+		}
+		p.in, p.out, ok = strings.Cut(types, ":")
+		if p.name = name; p.name == "" {
+			p.name = p.in + "To" + p.out
+		}
+		if !ok || !token.IsExported(p.in) || !token.IsExported(p.out) || !token.IsIdentifier(p.name) {
+			return nil, fmt.Errorf("invalid type pair %q, expected [Name=]In:Out with exported types", arg)
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// optionsLiteral returns the goloose.Options the flags ask for.
+func optionsLiteral() (string, error) {
+	var fields []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"StringToFloat64", *stringToFloat64},
+		{"UseNumber", *useNumber},
+		{"PreserveIntegers", *preserveIntegers},
+		{"DisallowUnknownFields", *disallowUnknownFields},
+		{"CollectErrors", *collectErrors},
+		{"CaseSensitive", *caseSensitive},
+		{"Reset", *reset},
+	} {
+		if opt.set {
+			fields = append(fields, opt.name+": true")
+		}
+	}
+	if *maxDepth != 0 {
+		fields = append(fields, "MaxDepth: "+strconv.Itoa(*maxDepth))
+	}
+	if *tagNames != "" {
+		var quoted []string
+		for _, name := range strings.Split(*tagNames, ",") {
+			quoted = append(quoted, strconv.Quote(strings.TrimSpace(name)))
+		}
+		fields = append(fields, "TagNames: []string{"+strings.Join(quoted, ", ")+"}")
+	}
+	if *names != "" {
+		mapper, ok := map[string]string{
+			"snake":           "SnakeCase",
+			"kebab":           "KebabCase",
+			"camel":           "CamelCase",
+			"screaming-snake": "ScreamingSnakeCase",
+		}[*names]
+		if !ok {
+			return "", fmt.Errorf("unknown -names %q", *names)
+		}
+		fields = append(fields, "NameMapper: goloose."+mapper)
+	}
+	return "goloose.Options{" + strings.Join(fields, ", ") + "}", nil
+}
+
+// stub returns a placeholder for the output, for building the package while it's being generated.
+func stub(pkgName string, pairs []pair) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by goloose-gen. DO NOT EDIT.\n\npackage %s\n", pkgName)
+	for _, p := range pairs {
+		fmt.Fprintf(&buf, "\nfunc %s(in *%s, out *%s) error {\n\tpanic(\"goloose-gen is still generating this\")\n}\n", p.name, p.in, p.out)
+	}
+	return buf.Bytes()
+}
+
+// writeOverlay writes stub to a temporary directory, along with a file for go build's -overlay flag
+// that replaces output with it, and returns the overlay file's path.
+func writeOverlay(output string, stub []byte) (string, error) {
+	dir, err := os.MkdirTemp("", "goloose-gen")
+	if err != nil {
+		return "", err
+	}
+	stubPath := filepath.Join(dir, "stub.go")
+	overlay, err := json.Marshal(map[string]any{"Replace": map[string]string{output: stubPath}})
+	if err == nil {
+		err = os.WriteFile(stubPath, stub, 0o644)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "overlay.json"), overlay, 0o644)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return filepath.Join(dir, "overlay.json"), nil
+}
+
+// writeFile replaces the file at path with src all at once, by writing a temporary file
+// next to it and renaming it, so that path is left as it was if anything goes wrong.
+func writeFile(path string, src []byte) error {
+	// go build ignores files starting with a dot
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(src)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// generate builds and runs a program that imports the package and calls goloose.Generate
+// with its types, returning the formatted source it writes. overlay is passed to go run's -overlay flag.
+func generate(pkgPath, pkgName string, pairs []pair, options, overlay string) ([]byte, error) {
+	// the program has to be in the package's module to import it, and directories
+	// starting with an underscore are ignored by ./... patterns while it exists
+	dir, err := os.MkdirTemp(".", "_goloose-gen")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, `package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/reillywatson/goloose"
+	target %q
+)
+
+func main() {
+	cfg := goloose.GenerateConfig{
+		PackageName: %q,
+		PackagePath: %q,
+		Options:     %s,
+	}
+`, pkgPath, pkgName, pkgPath, options)
+	for _, p := range pairs {
+		fmt.Fprintf(&src, "\tcfg.Pairs = append(cfg.Pairs, goloose.GeneratePair{Name: %q, In: reflect.TypeFor[target.%s](), Out: reflect.TypeFor[target.%s]()})\n", p.name, p.in, p.out)
+	}
+	src.WriteString(`	if err := goloose.Generate(os.Stdout, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+`)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), src.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command("go", "run", "-overlay", overlay, "./"+filepath.ToSlash(dir)).Output()
+	if err != nil {
+		return nil, commandError(err)
+	}
+	return format.Source(out)
+}
+
+// commandError includes what a failed command wrote to stderr in err.
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w\n%s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return err
+}
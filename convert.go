@@ -0,0 +1,156 @@
+package goloose
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// A Converter converts Ins into Outs the way ToStruct does, with options and a plan
+// that are checked once when it's made. It's safe to use from multiple goroutines.
+type Converter[In, Out any] struct {
+	options    Options
+	plan       *plan  // for In into Out, or nil if Ins need what ToStruct does to find one
+	generation uint64 // the planGeneration plan is from
+}
+
+// NewConverter returns a Converter from In to Out using options. It returns an error if
+// the options are invalid, or if JSON can't represent any In as an Out, like a struct as a string.
+// That check leaves out the nil pointers, slices and maps that are null in JSON, so it's stricter
+// than ToStruct: NewConverter[*int, string] fails, even though ToStruct converts a nil *int
+// into a string by leaving it alone.
+func NewConverter[In, Out any](options Options) (*Converter[In, Out], error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	inType, outType := reflect.TypeFor[In](), reflect.TypeFor[Out]()
+	if err := checkConvertible(inType, reflect.PointerTo(outType), options); err != nil {
+		return nil, err
+	}
+	c := &Converter[In, Out]{options: options}
+	if directlyConvertible(inType, outType, options) {
+		c.generation = planGeneration.Load()
+		c.plan = cachedPlan(inType, outType, options)
+	}
+	return c, nil
+}
+
+// Convert converts in into a new Out.
+func (c *Converter[In, Out]) Convert(in In) (Out, error) {
+	var out Out
+	err := c.ConvertInto(in, &out)
+	return out, err
+}
+
+// ConvertInto converts in into *out, like ToStruct(in, out).
+func (c *Converter[In, Out]) ConvertInto(in In, out *Out) error {
+	inVal := reflect.ValueOf(in)
+	if isNull(inVal, c.options) {
+		if c.options.Reset && out != nil {
+			resetNull(reflect.ValueOf(out).Elem())
+		}
+		return nil
+	}
+	if c.plan == nil || planGeneration.Load() != c.generation {
+		return convert(inVal, reflect.ValueOf(out), c.options)
+	}
+	// start from *out, like ToStruct does once it's followed the pointer
+	return unwrapSkipValError(toStructPlanned(inVal, reflect.ValueOf(out).Elem(), c.plan, c.options, recursion{}))
+}
+
+// directlyConvertible reports whether every value of type inType can be converted into the value an
+// *outType points to with the plan for inType into outType, because ToStruct would just follow the pointer.
+func directlyConvertible(inType, outType reflect.Type, options Options) bool {
+	if inType.Kind() == reflect.Interface || len(options.Transforms) > 0 {
+		// the plan depends on what's in it, or what the transforms make of it
+		return false
+	}
+	outPtrType := reflect.PointerTo(outType)
+	p := cachedPlan(inType, outPtrType, options)
+	if p.converter || p.goloose || p.customJson {
+		return false
+	}
+	for t := inType; ; t = t.Elem() {
+		if _, ok := findConverter(t, outPtrType, options); ok {
+			return false
+		}
+		if t.Kind() != reflect.Ptr {
+			return true
+		}
+	}
+}
+
+// checkConvertible returns an error if no value of type inType other than null can be converted
+// into outType, because their JSON representations never match and nothing else takes care of them.
+func checkConvertible(inType, outType reflect.Type, options Options) error {
+	if len(options.Converters) > 0 || len(options.Transforms) > 0 {
+		// these could change anything
+		return nil
+	}
+	for {
+		p := cachedPlan(inType, outType, options)
+		if p.converter || p.goloose || p.customJson {
+			return nil
+		}
+		// toStructImpl follows output pointers first, then input ones
+		if outType.Kind() == reflect.Ptr {
+			outType = outType.Elem()
+		} else if inType.Kind() == reflect.Ptr {
+			inType = inType.Elem()
+		} else {
+			break
+		}
+	}
+	inKind, ok := jsonKind(inType, options)
+	if !ok {
+		return nil
+	}
+	outKind, ok := jsonKind(outType, options)
+	if !ok || inKind == outKind || inKind == "string" && scalarFromString(outType, options) {
+		return nil
+	}
+	return fmt.Errorf("goloose: can't convert %v into %v: %w", inType, outType, &json.UnmarshalTypeError{Value: inKind, Type: outType})
+}
+
+// scalarFromString reports whether stringToScalar can convert some strings into values of type t
+// even though JSON represents them as something else.
+func scalarFromString(t reflect.Type, options Options) bool {
+	switch t.Kind() {
+	case reflect.Bool:
+		return options.Semantics != SemanticsV2
+	case reflect.Float64:
+		return options.StringToFloat64
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	case reflect.Array:
+		return isBinary(t, options)
+	}
+	return false
+}
+
+// jsonKind returns the kind of JSON value that values of type t are represented by,
+// or false if that depends on the value.
+func jsonKind(t reflect.Type, options Options) (string, bool) {
+	if t == numberType {
+		// these can be unmarshaled from strings as well as numbers
+		return "", false
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "object", true
+	case reflect.Slice, reflect.Array:
+		if isBinary(t, options) {
+			return "string", true
+		}
+		return "array", true
+	case reflect.String:
+		return "string", true
+	case reflect.Bool:
+		return "bool", true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number", true
+	}
+	return "", false
+}
@@ -0,0 +1,109 @@
+package goloose
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// A TypeConverter converts values of one type directly into another, instead of
+// going through their JSON representations. Make one with ConverterFunc.
+type TypeConverter struct {
+	in, out reflect.Type
+	convert func(in, out reflect.Value) error
+}
+
+// ConverterFunc returns a TypeConverter that converts Ins into Outs with fn,
+// for use in Options.Converters. Converters are checked before anything else goloose
+// does with a value, except Options.Transforms, which run first: the converter used is
+// the one for the transformed value's type, and that's the value fn gets.
+func ConverterFunc[In, Out any](fn func(In) (Out, error)) TypeConverter {
+	return TypeConverter{
+		in:  reflect.TypeFor[In](),
+		out: reflect.TypeFor[Out](),
+		convert: func(in, out reflect.Value) error {
+			inVal, _ := reflect.TypeAssert[In](in)
+			res, err := fn(inVal)
+			if err != nil {
+				return err
+			}
+			if out.CanSet() {
+				outPtr, _ := reflect.TypeAssert[*Out](out.Addr())
+				*outPtr = res
+			} else {
+				out.Set(reflect.ValueOf(&res).Elem())
+			}
+			return nil
+		},
+	}
+}
+
+// RegisterConverter makes every conversion from an In into an Out use fn, unless
+// Options.Converters has a converter for the same types. It's safe to call concurrently,
+// but it's meant to be called during initialization, like from an init function.
+func RegisterConverter[In, Out any](fn func(In) (Out, error)) {
+	c := ConverterFunc(fn)
+	globalConverters.mu.Lock()
+	defer globalConverters.mu.Unlock()
+	m, _ := globalConverters.value.Load().(map[converterKey]TypeConverter)
+	newM := make(map[converterKey]TypeConverter, len(m)+1)
+	for k, v := range m {
+		newM[k] = v
+	}
+	newM[converterKey{c.in, c.out}] = c
+	globalConverters.value.Store(newM)
+	// plans remember whether there's a converter for their types
+	resetPlans()
+}
+
+type converterKey struct {
+	in, out reflect.Type
+}
+
+var globalConverters struct {
+	value atomic.Value // map[converterKey]TypeConverter
+	mu    sync.Mutex   // used only by writers
+}
+
+// findConverter returns the converter that options or RegisterConverter have for inType and outType.
+func findConverter(inType, outType reflect.Type, options Options) (TypeConverter, bool) {
+	for _, c := range options.Converters {
+		if c.in == inType && c.out == outType {
+			return c, true
+		}
+	}
+	m, _ := globalConverters.value.Load().(map[converterKey]TypeConverter)
+	c, ok := m[converterKey{inType, outType}]
+	return c, ok
+}
+
+// convertWithConverter converts in into out with a registered converter, if there is one.
+// Like json.Marshal, pointers and interfaces are followed to the values they hold.
+func convertWithConverter(in, out reflect.Value, options Options) (bool, error) {
+	if len(options.Converters) == 0 {
+		if m, _ := globalConverters.value.Load().(map[converterKey]TypeConverter); len(m) == 0 {
+			return false, nil
+		}
+	}
+	outType := out.Type()
+	for {
+		if c, ok := findConverter(in.Type(), outType, options); ok {
+			return true, callConverter(c, in, out)
+		}
+		if in.Kind() != reflect.Ptr && in.Kind() != reflect.Interface || in.IsNil() {
+			return false, nil
+		}
+		in = in.Elem()
+	}
+}
+
+// callConverter converts in into out with c, turning a panic in its function into an error like marshalJSON does.
+func callConverter(c TypeConverter, in, out reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PathError{Err: fmt.Errorf("panic converting %v into %v: %v", c.in, c.out, r)}
+		}
+	}()
+	return c.convert(in, out)
+}
@@ -0,0 +1,216 @@
+package goloose
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A ChangeKind says what a Change does.
+type ChangeKind int
+
+const (
+	// ChangeModified is a value that's in both a and b, but different.
+	ChangeModified ChangeKind = iota
+	// ChangeAdded is a value that's only in b.
+	ChangeAdded
+	// ChangeRemoved is a value that's only in a.
+	ChangeRemoved
+)
+
+// A Change is a difference between two values that Diff found.
+type Change struct {
+	Kind ChangeKind
+	Path string // dot-separated like PathError.Path, or "" for the values themselves
+	From any    // the value in a, as ToStruct would convert it into an interface{} with Options.UseNumber; nil for ChangeAdded
+	To   any    // the value in b, the same way; nil for ChangeRemoved
+}
+
+// Equal reports whether a and b, which can have different types, would be the same JSON if they were
+// marshaled: fields are named, omitted and quoted the way json.Marshal would, and numbers are compared
+// by value, so int(1) equals float64(1). Nothing is actually marshaled, apart from values with their own
+// MarshalJSON methods. A value that can't be marshaled, like one containing a NaN, isn't equal to anything,
+// and neither is anything compared with invalid options.
+func Equal(a, b any, options ...Options) bool {
+	opt, err := diffOptions(options)
+	if err != nil {
+		return false
+	}
+	x, err := jsonValue(a, opt)
+	if err != nil {
+		return false
+	}
+	y, err := jsonValue(b, opt)
+	if err != nil {
+		return false
+	}
+	return jsonEqual(x, y)
+}
+
+// Diff returns the differences between a and b, compared the way Equal does, ordered by path with object
+// keys sorted. Objects are compared member by member and arrays element by element, with the extra elements
+// of a longer array added, or removed starting from the end; anything else that changed is a single
+// ChangeModified. Parts of a and b that can't be marshaled are left out, like ToStruct does with
+// Options.CollectErrors. It only returns an error if options aren't valid.
+func Diff(a, b any, options ...Options) ([]Change, error) {
+	opt, err := diffOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	opt.CollectErrors = true
+	x, _ := jsonValue(a, opt)
+	y, _ := jsonValue(b, opt)
+	var changes []Change
+	diffJSON(nil, x, y, func(kind ChangeKind, path []string, from, to any) {
+		changes = append(changes, Change{Kind: kind, Path: strings.Join(path, "."), From: from, To: to})
+	})
+	return changes, nil
+}
+
+// diffOptions returns the Options passed to Equal, Diff or CreatePatch, or an error if they aren't valid.
+func diffOptions(options []Options) (Options, error) {
+	var opt Options
+	if len(options) > 1 {
+		return opt, fmt.Errorf("pass at most one Options struct")
+	} else if len(options) == 1 {
+		opt = options[0]
+	}
+	if err := opt.validate(); err != nil {
+		return opt, err
+	}
+	opt.UseNumber = true
+	return opt, nil
+}
+
+// jsonValue returns v as the interface{} that unmarshaling its JSON would give, with json.Numbers for numbers.
+func jsonValue(v any, options Options) (any, error) {
+	var out any
+	err := ToStruct(v, &out, options)
+	return out, err
+}
+
+// diffJSON calls change for each difference between x and y, which are at path.
+func diffJSON(path []string, x, y any, change func(kind ChangeKind, path []string, from, to any)) {
+	member := func(name string) []string {
+		return append(path[:len(path):len(path)], name)
+	}
+	switch x := x.(type) {
+	case map[string]any:
+		y, ok := y.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(x)+len(y))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			xv, inX := x[k]
+			yv, inY := y[k]
+			switch {
+			case !inY:
+				change(ChangeRemoved, member(k), xv, nil)
+			case !inX:
+				change(ChangeAdded, member(k), nil, yv)
+			default:
+				diffJSON(member(k), xv, yv, change)
+			}
+		}
+		return
+	case []any:
+		y, ok := y.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(x) && i < len(y); i++ {
+			diffJSON(member(strconv.Itoa(i)), x[i], y[i], change)
+		}
+		for i := len(x) - 1; i >= len(y); i-- {
+			// from the end, so that each index is still right when the ones after it have been removed
+			change(ChangeRemoved, member(strconv.Itoa(i)), x[i], nil)
+		}
+		for i := len(x); i < len(y); i++ {
+			change(ChangeAdded, member(strconv.Itoa(i)), nil, y[i])
+		}
+		return
+	}
+	if !jsonEqual(x, y) {
+		change(ChangeModified, path, x, y)
+	}
+}
+
+// jsonEqual reports whether x and y, which jsonValue returned, are the same JSON.
+func jsonEqual(x, y any) bool {
+	switch x := x.(type) {
+	case map[string]any:
+		y, ok := y.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, xv := range x {
+			if yv, ok := y[k]; !ok || !jsonEqual(xv, yv) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := y.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := y.(json.Number)
+		return ok && numbersEqual(x, y)
+	case string:
+		y, ok := y.(string)
+		return ok && x == y
+	case bool:
+		y, ok := y.(bool)
+		return ok && x == y
+	case nil:
+		return y == nil
+	}
+	return false
+}
+
+// numbersEqual reports whether x and y have the same value, like 1, 1.0 and 1e0.
+func numbersEqual(x, y json.Number) bool {
+	if x == y {
+		return true
+	}
+	if hugeExponent(x) || hugeExponent(y) {
+		// big.Rat would need an enormous amount of memory for these, and they can't come from Go numbers
+		return false
+	}
+	xr, ok := new(big.Rat).SetString(string(x))
+	if !ok {
+		return false
+	}
+	yr, ok := new(big.Rat).SetString(string(y))
+	return ok && xr.Cmp(yr) == 0
+}
+
+// hugeExponent reports whether n has an exponent far beyond what float64s can have.
+func hugeExponent(n json.Number) bool {
+	i := strings.IndexAny(string(n), "eE")
+	if i < 0 {
+		return false
+	}
+	exp, err := strconv.Atoi(strings.TrimPrefix(string(n[i+1:]), "+"))
+	return err != nil || exp > 1000 || exp < -1000
+}
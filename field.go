@@ -2,11 +2,13 @@ package goloose
 
 import (
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"unicode"
+	"unicode/utf8"
 )
 
 /* Most of the code in here is taken from https://github.com/golang/go/blob/master/src/encoding/json/encode.go, and as such is:
@@ -42,21 +44,73 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
 // A field represents a single field found in a struct.
 type field struct {
-	name      string
-	namelower string
+	name       string
+	nameFolded string
 
 	tag       bool
 	index     []int
 	typ       reflect.Type
 	omitEmpty bool
+	omitZero  bool
+	isZero    func(reflect.Value) bool
 	quoted    bool
 }
 
+type structFields struct {
+	list         []field
+	byExactName  map[string]*field
+	byFoldedName map[string]*field
+}
+
+// lookup returns the field that the JSON object key name is decoded into, or nil if there isn't one.
+// Like json.Unmarshal, an exact match wins, followed by the first case-insensitive match.
+func (fs structFields) lookup(name string, caseSensitive bool) *field {
+	if f := fs.byExactName[name]; f != nil || caseSensitive {
+		return f
+	}
+	return fs.byFoldedName[foldName(name)]
+}
+
 func fillField(f field) field {
-	f.namelower = strings.ToLower(f.name)
+	f.nameFolded = foldName(f.name)
 	return f
 }
 
+// foldName returns a folded string such that foldName(x) == foldName(y)
+// is identical to strings.EqualFold(x, y), which is how json.Unmarshal matches keys to fields.
+// Unlike strings.ToLower, this folds the Kelvin sign 'K' to 'K' and the long s 'ſ' to 'S'.
+func foldName(in string) string {
+	var arr [32]byte // large enough for most JSON names
+	out := arr[:0]
+	for i := 0; i < len(in); {
+		// Handle single-byte ASCII.
+		if c := in[i]; c < utf8.RuneSelf {
+			if 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			out = append(out, c)
+			i++
+			continue
+		}
+		// Handle multi-byte Unicode.
+		r, n := utf8.DecodeRuneInString(in[i:])
+		out = utf8.AppendRune(out, foldRune(r))
+		i += n
+	}
+	return string(out)
+}
+
+// foldRune returns the smallest rune for all runes in the same fold set.
+func foldRune(r rune) rune {
+	for {
+		r2 := unicode.SimpleFold(r)
+		if r2 <= r {
+			return r2
+		}
+		r = r2
+	}
+}
+
 // byIndex sorts field by index sequence.
 type byIndex []field
 
@@ -98,7 +152,12 @@ func isValidTag(s string) bool {
 // typeFields returns a list of fields that JSON should recognize for the given type.
 // The algorithm is breadth-first search over the set of structs to include - the top struct
 // and then any reachable anonymous structs.
-func typeFields(t reflect.Type) []field {
+func typeFields(t reflect.Type, options Options) structFields {
+	tagNames := options.TagNames
+	if len(tagNames) == 0 {
+		tagNames = defaultTagNames
+	}
+
 	// Anonymous fields to explore at the current level and the next.
 	current := []field{}
 	next := []field{{typ: t}}
@@ -129,7 +188,7 @@ func typeFields(t reflect.Type) []field {
 				if sf.PkgPath != "" && !sf.Anonymous { // unexported
 					continue
 				}
-				tag := sf.Tag.Get("json")
+				tag := lookupTag(sf.Tag, tagNames)
 				if tag == "-" {
 					continue
 				}
@@ -166,15 +225,23 @@ func typeFields(t reflect.Type) []field {
 					tagged := name != ""
 					if name == "" {
 						name = sf.Name
+						if options.NameMapper != nil {
+							name = options.NameMapper.MapName(name)
+						}
 					}
-					fields = append(fields, fillField(field{
+					newField := fillField(field{
 						name:      name,
 						tag:       tagged,
 						index:     index,
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
+						omitZero:  opts.Contains("omitzero"),
 						quoted:    quoted,
-					}))
+					})
+					if newField.omitZero {
+						newField.isZero = isZeroFunc(sf.Type)
+					}
+					fields = append(fields, newField)
 					if count[f.typ] > 1 {
 						// If there were multiple instances, add a second,
 						// so that the annihilation code will see a duplicate.
@@ -242,7 +309,16 @@ func typeFields(t reflect.Type) []field {
 	fields = out
 	sort.Sort(byIndex(fields))
 
-	return fields
+	exactNameIndex := make(map[string]*field, len(fields))
+	foldedNameIndex := make(map[string]*field, len(fields))
+	for i, field := range fields {
+		exactNameIndex[field.name] = &fields[i]
+		// For historical reasons, first folded match takes precedence.
+		if _, ok := foldedNameIndex[field.nameFolded]; !ok {
+			foldedNameIndex[field.nameFolded] = &fields[i]
+		}
+	}
+	return structFields{fields, exactNameIndex, foldedNameIndex}
 }
 
 // dominantField looks through the fields, all of which are known to
@@ -283,33 +359,55 @@ func dominantField(fields []field) (field, bool) {
 	return fields[0], true
 }
 
+// defaultTagNames are the struct tags read when Options.TagNames is empty.
+var defaultTagNames = []string{"json"}
+
+// lookupTag returns the value of the first of tagNames that tag has.
+func lookupTag(tag reflect.StructTag, tagNames []string) string {
+	for _, name := range tagNames {
+		if value, ok := tag.Lookup(name); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// fieldCacheKey identifies a struct type's fields, which depend on how we name them.
+type fieldCacheKey struct {
+	typ        reflect.Type
+	tagNames   string // joined with spaces, which can't appear in tag names
+	nameMapper NameMapper
+}
+
 var fieldCache struct {
-	value atomic.Value // map[reflect.Type][]field
+	value atomic.Value // map[fieldCacheKey]structFields
 	mu    sync.Mutex   // used only by writers
 }
 
 // cachedTypeFields is like typeFields but uses a cache to avoid repeated work.
-func cachedTypeFields(t reflect.Type) []field {
-	m, _ := fieldCache.value.Load().(map[reflect.Type][]field)
-	f := m[t]
-	if f != nil {
+func cachedTypeFields(t reflect.Type, options Options) structFields {
+	if options.NameMapper != nil && !reflect.TypeOf(options.NameMapper).Comparable() {
+		// this can't be a map key
+		return typeFields(t, options)
+	}
+	key := fieldCacheKey{t, strings.Join(options.TagNames, " "), options.NameMapper}
+	m, _ := fieldCache.value.Load().(map[fieldCacheKey]structFields)
+	f, ok := m[key]
+	if ok {
 		return f
 	}
 
 	// Compute fields without lock.
 	// Might duplicate effort but won't hold other computations back.
-	f = typeFields(t)
-	if f == nil {
-		f = []field{}
-	}
+	f = typeFields(t, options)
 
 	fieldCache.mu.Lock()
-	m, _ = fieldCache.value.Load().(map[reflect.Type][]field)
-	newM := make(map[reflect.Type][]field, len(m)+1)
+	m, _ = fieldCache.value.Load().(map[fieldCacheKey]structFields)
+	newM := make(map[fieldCacheKey]structFields, len(m)+1)
 	for k, v := range m {
 		newM[k] = v
 	}
-	newM[t] = f
+	newM[key] = f
 	fieldCache.value.Store(newM)
 	fieldCache.mu.Unlock()
 	return f
@@ -335,6 +433,105 @@ func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
 	return v
 }
 
+// zeroFieldsExcept zeroes the fields of the struct v, except the ones at indexes, which are relative
+// to v. The embedded structs those are in are kept, and so are unexported fields, which nothing sets.
+func zeroFieldsExcept(v reflect.Value, indexes [][]int) {
+	for i := 0; i < v.NumField(); i++ {
+		var inner [][]int
+		keep := false
+		for _, index := range indexes {
+			if index[0] != i {
+				continue
+			}
+			if len(index) == 1 {
+				keep = true
+				break
+			}
+			inner = append(inner, index[1:])
+		}
+		f := v.Field(i)
+		switch {
+		case keep:
+		case inner != nil:
+			if f.Kind() == reflect.Ptr {
+				// fieldByIndex allocated this if it had to
+				f = f.Elem()
+			}
+			zeroFieldsExcept(f, inner)
+		case f.CanSet():
+			if !f.IsZero() {
+				f.Set(reflect.Zero(f.Type()))
+			}
+		case f.Kind() == reflect.Struct && v.Type().Field(i).Anonymous:
+			// an unexported embedded struct can still have exported fields
+			zeroFieldsExcept(f, nil)
+		}
+	}
+}
+
+// containsIndex reports whether indexes has index in it.
+func containsIndex(indexes [][]int, index []int) bool {
+	return slices.ContainsFunc(indexes, func(i []int) bool { return slices.Equal(i, index) })
+}
+
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeFor[isZeroer]()
+
+// isZeroFunc returns a function that uses t's IsZero method, or nil if t doesn't have one.
+// A value that isn't of type t (as happens when an embedded pointer is nil) counts as zero.
+func isZeroFunc(t reflect.Type) func(reflect.Value) bool {
+	switch {
+	case t.Kind() == reflect.Interface && t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			// Avoid panics calling IsZero on a nil interface or
+			// non-nil interface with nil pointer.
+			return v.IsNil() ||
+				(v.Elem().Kind() == reflect.Ptr && v.Elem().IsNil()) ||
+				v.Interface().(isZeroer).IsZero()
+		}
+	case t.Kind() == reflect.Ptr && t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			// Avoid panics calling IsZero on nil pointer.
+			return v.IsNil() || v.Interface().(isZeroer).IsZero()
+		}
+	case t.Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			z, ok := v.Interface().(isZeroer)
+			return !ok || z.IsZero()
+		}
+	case reflect.PointerTo(t).Implements(isZeroerType):
+		return func(v reflect.Value) bool {
+			if !v.CanAddr() {
+				// Temporarily box v so we can take the address.
+				v2 := reflect.New(v.Type()).Elem()
+				v2.Set(v)
+				v = v2
+			}
+			z, ok := v.Addr().Interface().(isZeroer)
+			return !ok || z.IsZero()
+		}
+	}
+	return nil
+}
+
+// fieldByIndexNoAlloc is like fieldByIndex without allocating, and reports false
+// if the field can't be reached because an embedded struct pointer is nil.
+func fieldByIndexNoAlloc(v reflect.Value, index []int) (reflect.Value, bool) {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v, true
+}
+
 // tagOptions is the string following a comma in a struct field's "json"
 // tag, or the empty string. It does not include the leading comma.
 type tagOptions string
@@ -1,6 +1,8 @@
 package goloose
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -50,6 +52,40 @@ A *string ` + "`" + `json:"a"` + "`" + `
 B *struct {
 	Bar string ` + "`" + `json:"bar"` + "`" + `
 } ` + "`" + `json:"b"` + "`" + `
+}{}`,
+		`var in = struct{
+	A [3]int ` + "`" + `json:"a"` + "`" + `
+	B [2]byte ` + "`" + `json:"b"` + "`" + `
+		}{[3]int{1, 2, 3}, [2]byte{4, 5}}
+var out = struct{
+	A [2]int ` + "`" + `json:"a"` + "`" + `
+	B []int ` + "`" + `json:"b"` + "`" + `
+}{}`,
+		`var in = struct{
+	A []int ` + "`" + `json:"a"` + "`" + `
+	B [2]float64 ` + "`" + `json:"b"` + "`" + `
+		}{[]int{1}, [2]float64{1.5, 2}}
+var out = struct{
+	A [4]int ` + "`" + `json:"a"` + "`" + `
+	B any ` + "`" + `json:"b"` + "`" + `
+}{}`,
+		`var in = map[string]any{"K": 1, "ſize": 2, "Straße": "foo", "Σ": 3}
+var out = struct{
+	K int ` + "`" + `json:"k"` + "`" + `
+	Size int ` + "`" + `json:"SIZE"` + "`" + `
+	Straße string
+	Σ int ` + "`" + `json:"σ"` + "`" + `
+}{}`,
+		`var in = struct{
+	Ǆ int
+	Kelvin int ` + "`" + `json:"K"` + "`" + `
+	Ω string ` + "`" + `json:"ω"` + "`" + `
+		}{1, 2, "foo"}
+var out = struct{
+	ǆ int
+	Ǆ int ` + "`" + `json:"ǅ"` + "`" + `
+	K int
+	Ω any ` + "`" + `json:"Ω"` + "`" + `
 }{}`,
 	}
 	for _, tc := range testcases {
@@ -69,12 +105,179 @@ B *struct {
 		if len(file.Decls) != 2 {
 			return
 		}
-		validateFuzz(t, file.Decls[0], file.Decls[1], code, debugging)
-		validateFuzz(t, file.Decls[1], file.Decls[0], code, debugging)
+		validateFuzz(t, file.Decls[0], file.Decls[1], code, debugging, Options{}, toStructSlow)
+		validateFuzz(t, file.Decls[1], file.Decls[0], code, debugging, Options{}, toStructSlow)
+		if jsonv2Available {
+			validateFuzz(t, file.Decls[0], file.Decls[1], code, debugging, Options{Semantics: SemanticsV2}, toStructSlowV2)
+			validateFuzz(t, file.Decls[1], file.Decls[0], code, debugging, Options{Semantics: SemanticsV2}, toStructSlowV2)
+		}
+		validateReset(t, file.Decls[0], file.Decls[1])
+		validateClone(t, file.Decls[0])
+		validateClone(t, file.Decls[1])
+		validateEqual(t, file.Decls[0])
+		validateEqual(t, file.Decls[1])
+		validatePatch(t, file.Decls[0])
+		validatePatch(t, file.Decls[1])
 	})
 }
 
-func validateFuzz(t *testing.T, inDecl, outDecl ast.Decl, code string, debugging bool) {
+// validateReset checks that Options.Reset gives the same result as converting into a zero value,
+// both into the output as declared and into what converting the input into it gave.
+func validateReset(t *testing.T, inDecl, outDecl ast.Decl) {
+	in, err := loadTypespecFromAST(inDecl)
+	if err != nil || in == nil {
+		return
+	}
+	out, err := loadTypespecFromAST(outDecl)
+	if err != nil || out == nil {
+		return
+	}
+	if strings.Contains(reflect.TypeOf(in).String(), "reflect.") || strings.Contains(reflect.TypeOf(out).String(), "reflect.") {
+		return
+	}
+	check := func(in any, prefilled reflect.Value) {
+		want := reflect.New(prefilled.Type())
+		if err := ToStruct(in, want.Interface()); err != nil {
+			return
+		}
+		got := reflect.New(prefilled.Type())
+		got.Elem().Set(prefilled)
+		if err := ToStruct(in, got.Interface(), Options{Reset: true}); err != nil {
+			t.Errorf("ToStruct with Reset failed when it didn't without: %v", err)
+		} else if !reflect.DeepEqual(got.Elem().Interface(), want.Elem().Interface()) {
+			t.Errorf("Got %+v\nExpected %+v with Reset", got.Elem(), want.Elem())
+		}
+	}
+	check(in, reflect.ValueOf(out))
+
+	converted := reflect.New(reflect.TypeOf(out))
+	if err := ToStruct(in, converted.Interface()); err == nil {
+		check(out, converted.Elem())
+	}
+}
+
+// validateEqual checks that a value is Equal to what unmarshaling its JSON gives, and has no Diff with it.
+func validateEqual(t *testing.T, decl ast.Decl) {
+	v, err := loadTypespecFromAST(decl)
+	if err != nil || v == nil || strings.Contains(reflect.TypeOf(v).String(), "reflect.") {
+		return
+	}
+	if jsonv2Available && hasInvalidTagName(reflect.TypeOf(v)) {
+		// like validateFuzz, json.Marshal uses names here that goloose doesn't
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	var decoded any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(v, decoded) {
+		t.Errorf("%+v isn't Equal to its JSON %s", v, b)
+	}
+	if changes, err := Diff(v, decoded); err != nil || len(changes) != 0 {
+		t.Errorf("Diff of %+v and its JSON %s: %+v, %v", v, b, changes, err)
+	}
+}
+
+// validatePatch checks that CreatePatch makes patches that ApplyPatch turns one value into another with,
+// from a zero value to the value and back.
+func validatePatch(t *testing.T, decl ast.Decl) {
+	v, err := loadTypespecFromAST(decl)
+	if err != nil || v == nil || strings.Contains(reflect.TypeOf(v).String(), "reflect.") {
+		return
+	}
+	typ := reflect.TypeOf(v)
+	converted := reflect.New(typ)
+	if err := ToStruct(v, converted.Interface()); err != nil || !Equal(converted.Elem().Interface(), v) {
+		// ApplyPatch converts values like ToStruct does, so it can't make a value ToStruct can't
+		return
+	}
+	zero := reflect.Zero(typ).Interface()
+	for _, pair := range [][2]any{{zero, v}, {v, zero}} {
+		ops, err := CreatePatch(pair[0], pair[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := reflect.New(typ)
+		if err := ToStruct(pair[0], target.Interface()); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyPatch(target.Interface(), ops); err != nil {
+			t.Fatalf("applying %+v to %+v: %v", ops, pair[0], err)
+		}
+		if !Equal(target.Elem().Interface(), pair[1]) {
+			t.Errorf("applying %+v to %+v got %+v, expected %+v", ops, pair[0], target.Elem().Interface(), pair[1])
+		}
+	}
+}
+
+// validateClone checks that Clone gives what ConvertTo does, and that AllFields copies everything.
+func validateClone(t *testing.T, decl ast.Decl) {
+	v, err := loadTypespecFromAST(decl)
+	if err != nil || v == nil {
+		return
+	}
+	typ := reflect.TypeOf(v)
+	if strings.Contains(typ.String(), "reflect.") || hasInterface(typ, map[reflect.Type]bool{}) {
+		// Clone keeps what interfaces hold, where ConvertTo turns them into maps and float64s
+		return
+	}
+	semantics := []Semantics{SemanticsV1}
+	if jsonv2Available {
+		semantics = append(semantics, SemanticsV2)
+	}
+	for _, sem := range semantics {
+		want := reflect.New(typ)
+		if err := ToStruct(v, want.Interface(), Options{Semantics: sem}); err != nil {
+			continue
+		}
+		got, err := CloneOpts(v, CloneOptions{Options: Options{Semantics: sem}})
+		if err != nil {
+			t.Errorf("CloneOpts failed when ToStruct didn't: %v", err)
+		} else if !reflect.DeepEqual(got, want.Elem().Interface()) {
+			t.Errorf("Got %+v\nExpected %+v from Clone with %v", got, want.Elem(), sem)
+		}
+	}
+	if reflect.DeepEqual(v, v) {
+		// it's not, with NaNs
+		if got, err := CloneOpts(v, CloneOptions{AllFields: true}); err != nil {
+			t.Errorf("CloneOpts with AllFields failed: %v", err)
+		} else if !reflect.DeepEqual(got, v) {
+			t.Errorf("Got %+v\nExpected %+v from Clone with AllFields", got, v)
+		}
+	}
+}
+
+// hasInterface reports whether values of type t can hold interfaces.
+func hasInterface(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return hasInterface(t.Elem(), seen)
+	case reflect.Map:
+		return hasInterface(t.Key(), seen) || hasInterface(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasInterface(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateFuzz checks that ToStruct with options gives the same result as the reference implementation slow.
+func validateFuzz(t *testing.T, inDecl, outDecl ast.Decl, code string, debugging bool, options Options, slow func(in, out any) error) {
 	parseErr := func(code string, err error) {
 		if debugging {
 			fmt.Println("Parse error: ", err)
@@ -104,20 +307,29 @@ func validateFuzz(t *testing.T, inDecl, outDecl ast.Decl, code string, debugging
 		parseErr(code, fmt.Errorf("unexpected results! In: %+#v, Out: %+#v", in, out))
 		return
 	}
-	if err := ToStruct(in, &out); err != nil {
-		if err := toStructSlow(in, &outSlow); err == nil {
-			t.Errorf("ToStruct failed but toStructSlow succeeded! Error: %v", err)
+	if options.Semantics == SemanticsV1 && jsonv2Available && (hasInvalidTagName(reflect.TypeOf(in)) || hasInvalidTagName(reflect.TypeOf(out))) {
+		// encoding/json built on v2 accepts some tag names that encoding/json itself ignores
+		parseErr(code, fmt.Errorf("invalid tag name"))
+		return
+	}
+	if options.Semantics == SemanticsV1 && hasCaseVariantNames(reflect.ValueOf(in), options) {
+		// goloose prefers a key that matches a field exactly, where encoding/json lets the last one win
+		slow = toStructSlowExact
+	}
+	if err := ToStruct(in, &out, options); err != nil {
+		if err2 := slow(in, &outSlow); err2 == nil {
+			t.Errorf("ToStruct failed but the reference succeeded with semantics %d! Error: %v", options.Semantics, err)
 		}
 		return
 	}
 	if debugging {
 		fmt.Println("IN:", toJson(in), "OUT:", toJson(out))
 	}
-	if err := toStructSlow(in, &outSlow); err != nil {
+	if err := slow(in, &outSlow); err != nil {
 		return // can't JSON compare!
 	}
 	if !reflect.DeepEqual(out, outSlow) {
-		t.Errorf("Got %+v\nExpected %+v", out, outSlow)
+		t.Errorf("Got %+v\nExpected %+v with semantics %d", out, outSlow, options.Semantics)
 	}
 }
 
@@ -153,11 +365,22 @@ func loadTypespecFromAST(expr any) (any, error) {
 			if !inVal.IsValid() {
 				return nil, fmt.Errorf("invalid value")
 			}
+			if inVal.Kind() == reflect.Ptr && inVal.Elem().Kind() == reflect.Array {
+				// nested array literals come back addressable, but arrays are values
+				inVal = inVal.Elem()
+			}
 			switch val.Kind() {
 			case reflect.Slice:
 				if inVal.CanConvert(val.Type()) {
 					val = reflect.Append(val, inVal)
 				}
+			case reflect.Array:
+				if i >= val.Len() {
+					return nil, fmt.Errorf("index %d out of bounds for %v", i, val.Type())
+				}
+				if inVal.CanConvert(val.Type().Elem()) {
+					val.Index(i).Set(inVal.Convert(val.Type().Elem()))
+				}
 			case reflect.Struct:
 				if val.Field(i).CanSet() && inVal.CanConvert(val.Field(i).Type()) {
 					val.Field(i).Set(inVal.Convert(val.Field(i).Type()))
@@ -231,7 +454,22 @@ func loadTypespecFromAST(expr any) (any, error) {
 		if err != nil {
 			return nil, err
 		}
-		return reflect.SliceOf(arrayType.(reflect.Type)), nil
+		elemType, ok := arrayType.(reflect.Type)
+		if !ok {
+			return nil, fmt.Errorf("expected reflect.Type, got %q", reflect.TypeOf(arrayType))
+		}
+		if expr.Len == nil {
+			return reflect.SliceOf(elemType), nil
+		}
+		lenLit, ok := expr.Len.(*ast.BasicLit)
+		if !ok || lenLit.Kind != token.INT {
+			return nil, fmt.Errorf("unsupported array length %v", expr.Len)
+		}
+		n, err := strconv.Atoi(lenLit.Value)
+		if err != nil || n < 0 || n > 64 {
+			return nil, fmt.Errorf("invalid array length %q", lenLit.Value)
+		}
+		return reflect.ArrayOf(n, elemType), nil
 	case *ast.MapType:
 		keyType, err := loadTypespecFromAST(expr.Key)
 		if err != nil {
@@ -305,8 +543,7 @@ func loadTypespecFromAST(expr any) (any, error) {
 			return nil, err
 		}
 		pkgPath := ""
-		c := expr.Names[0].Name[0]
-		if 'a' <= c && c <= 'z' || c == '_' {
+		if !token.IsExported(expr.Names[0].Name) {
 			pkgPath = "goloose"
 		}
 		val := reflect.StructField{
@@ -324,6 +561,8 @@ func loadTypespecFromAST(expr any) (any, error) {
 			return reflect.TypeOf(""), nil
 		case "float64":
 			return reflect.TypeOf(float64(0)), nil
+		case "byte":
+			return reflect.TypeOf(byte(0)), nil
 		case "any":
 			return reflect.TypeOf(new(any)).Elem(), nil
 		}
@@ -334,6 +573,200 @@ func loadTypespecFromAST(expr any) (any, error) {
 	return nil, fmt.Errorf("shouldn't get here")
 }
 
+// hasInvalidTagName reports whether t contains a struct field with a json tag name that isValidTag rejects.
+func hasInvalidTagName(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasInvalidTagName(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			name, _ := parseTag(t.Field(i).Tag.Get("json"))
+			if name != "" && name != "-" && !isValidTag(name) || hasInvalidTagName(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toStructSlowExact is toStructSlow, except that the keys of objects going into structs that only match
+// a field case-insensitively are dropped when another key matches it exactly, which is the one ToStruct uses.
+func toStructSlowExact(in, out any) error {
+	if in == nil {
+		return nil
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+	// like json.Unmarshal, follow pointers, and interfaces holding them, to what's decoded into
+	outVal := reflect.ValueOf(out)
+	for (outVal.Kind() == reflect.Ptr || outVal.Kind() == reflect.Interface && outVal.Elem().Kind() == reflect.Ptr) && !outVal.IsNil() {
+		outVal = outVal.Elem()
+	}
+	v = dropInexactNames(v, outVal.Type())
+	if b, err = json.Marshal(v); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &out)
+}
+
+// An orderedObject is a JSON object that keeps its members in order, which decides
+// which one encoding/json uses when several of them match the same field.
+type orderedObject []orderedMember
+
+type orderedMember struct {
+	key string
+	val any
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		val, err := json.Marshal(m.val)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeOrdered decodes the next JSON value from dec, with orderedObjects for its objects.
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := orderedObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, orderedMember{key.(string), val})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			val, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// dropInexactNames drops the members of the objects in v that are going into structs, as values of type t,
+// and only match a field case-insensitively while another member matches it exactly.
+func dropInexactNames(v any, t reflect.Type) any {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := v.(type) {
+	case orderedObject:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := cachedTypeFields(t, Options{})
+			exact := map[string]bool{}
+			for _, m := range v {
+				exact[m.key] = true
+			}
+			var kept orderedObject
+			for _, m := range v {
+				f := fields.lookup(m.key, false)
+				if f == nil {
+					kept = append(kept, m)
+					continue
+				}
+				if f.name != m.key && exact[f.name] {
+					continue
+				}
+				kept = append(kept, orderedMember{m.key, dropInexactNames(m.val, f.typ)})
+			}
+			return kept
+		case reflect.Map:
+			for i := range v {
+				v[i].val = dropInexactNames(v[i].val, t.Elem())
+			}
+		}
+	case []any:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i := range v {
+				v[i] = dropInexactNames(v[i], t.Elem())
+			}
+		}
+	}
+	return v
+}
+
+// hasCaseVariantNames reports whether v contains an object with keys or field names that differ but fold to the same name.
+func hasCaseVariantNames(v reflect.Value, options Options) bool {
+	var names []string
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return !v.IsNil() && hasCaseVariantNames(v.Elem(), options)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if hasCaseVariantNames(v.Index(i), options) {
+				return true
+			}
+		}
+		return false
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if name, err := mapKeyString(iter.Key(), options); err == nil {
+				names = append(names, name)
+			}
+			if hasCaseVariantNames(iter.Value(), options) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for _, f := range cachedTypeFields(v.Type(), options).list {
+			names = append(names, f.name)
+			if fv, ok := fieldByIndexNoAlloc(v, f.index); ok && hasCaseVariantNames(fv, options) {
+				return true
+			}
+		}
+	}
+	folded := map[string]string{}
+	for _, name := range names {
+		if other, ok := folded[foldName(name)]; ok && other != name {
+			return true
+		}
+		folded[foldName(name)] = name
+	}
+	return false
+}
+
 func isValidFieldName(fieldName string) bool {
 	for i, c := range fieldName {
 		if i == 0 && !isLetter(c) {
@@ -0,0 +1,846 @@
+package goloose
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A GeneratePair asks Generate for a function called Name that converts an In into an Out:
+//
+//	func Name(in *In, out *Out) error
+type GeneratePair struct {
+	Name    string
+	In, Out reflect.Type
+}
+
+// GenerateConfig describes a Go source file for Generate to write.
+type GenerateConfig struct {
+	PackageName string // the name of the package the file is in
+	PackagePath string // the import path of the package, which decides which unexported names the file can use
+	Pairs       []GeneratePair
+
+	// Options are the options the generated functions convert with. They can't have Transforms,
+	// Converters, SemanticsV2, or a NameMapper other than the built-in ones.
+	Options Options
+}
+
+// Generate writes Go source with the functions cfg asks for. They convert *in into *out the same
+// way ToStruct(in, out, cfg.Options) does, but struct fields are matched up ahead of time, and fields
+// holding structs, numbers, strings, bools and slices of those are converted without reflection, as
+// are maps of structs with string keys unless cfg.Options.CollectErrors is set. Everything else, like
+// other maps, interfaces and types with their own JSON methods, is left to goloose at run time. Converters registered with RegisterConverter are only taken into account if they're
+// registered when Generate runs. cmd/goloose-gen runs this for types in your own packages.
+func Generate(w io.Writer, cfg GenerateConfig) error {
+	g, err := newGenerator(cfg)
+	if err != nil {
+		return err
+	}
+	for _, pair := range cfg.Pairs {
+		if err := g.writePair(pair); err != nil {
+			return err
+		}
+	}
+	for len(g.pending) > 0 {
+		pair := g.pending[0]
+		g.pending = g.pending[1:]
+		g.writeHelper(pair)
+	}
+	_, err = w.Write(g.file())
+	return err
+}
+
+type typePair struct {
+	in, out reflect.Type
+}
+
+type generator struct {
+	cfg     GenerateConfig
+	pkg     string // how the file refers to this package, like "goloose."
+	options string // the variable holding cfg.Options
+	prefix  string // starts the names of the helper functions
+
+	imports   map[string]string // import paths to the names the file uses for them
+	names     map[string]bool   // the names the file declares or imports
+	helpers   map[typePair]string
+	pending   []typePair
+	usesMath  bool
+	recursive bool // whether the helper being written follows a pointer to another one
+
+	body   bytes.Buffer
+	indent int
+}
+
+const golooseImportPath = "github.com/reillywatson/goloose"
+
+func newGenerator(cfg GenerateConfig) (*generator, error) {
+	if !token.IsIdentifier(cfg.PackageName) {
+		return nil, fmt.Errorf("goloose: invalid package name %q", cfg.PackageName)
+	}
+	if len(cfg.Pairs) == 0 {
+		return nil, fmt.Errorf("goloose: no types to generate conversions for")
+	}
+	if err := cfg.Options.validate(); err != nil {
+		return nil, err
+	}
+	g := &generator{
+		cfg:     cfg,
+		imports: map[string]string{},
+		names:   map[string]bool{},
+		helpers: map[typePair]string{},
+	}
+	if cfg.PackagePath != golooseImportPath {
+		g.pkg = g.importName(golooseImportPath) + "."
+	}
+	for _, pair := range cfg.Pairs {
+		if !token.IsIdentifier(pair.Name) || g.names[pair.Name] {
+			return nil, fmt.Errorf("goloose: invalid or duplicate function name %q", pair.Name)
+		}
+		if pair.In == nil || pair.Out == nil {
+			return nil, fmt.Errorf("goloose: %s is missing a type", pair.Name)
+		}
+		g.names[pair.Name] = true
+	}
+	g.prefix = lowerFirst(cfg.Pairs[0].Name)
+	g.options = g.declare(g.prefix + "Options")
+	return g, nil
+}
+
+// declare reserves name, or a variation of it if it's taken, and returns the one it reserved.
+func (g *generator) declare(name string) string {
+	declared := name
+	for i := 2; g.names[declared]; i++ {
+		declared = name + strconv.Itoa(i)
+	}
+	g.names[declared] = true
+	return declared
+}
+
+func lowerFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+func upperFirst(s string) string {
+	r := []rune(s)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// optionsLiteral returns a composite literal for cfg.Options.
+func (g *generator) optionsLiteral() (string, error) {
+	o := g.cfg.Options
+	if len(o.Transforms) > 0 || len(o.Converters) > 0 || o.Semantics != SemanticsV1 {
+		return "", fmt.Errorf("goloose: generated code can't use Transforms, Converters or SemanticsV2")
+	}
+	var fields []string
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"StringToFloat64", o.StringToFloat64},
+		{"UseNumber", o.UseNumber},
+		{"PreserveIntegers", o.PreserveIntegers},
+		{"DisallowUnknownFields", o.DisallowUnknownFields},
+		{"CollectErrors", o.CollectErrors},
+		{"CaseSensitive", o.CaseSensitive},
+		{"Reset", o.Reset},
+	} {
+		if opt.set {
+			fields = append(fields, opt.name+": true")
+		}
+	}
+	if o.MaxDepth != 0 {
+		fields = append(fields, "MaxDepth: "+strconv.Itoa(o.MaxDepth))
+	}
+	if len(o.TagNames) > 0 {
+		quoted := make([]string, len(o.TagNames))
+		for i, name := range o.TagNames {
+			quoted[i] = strconv.Quote(name)
+		}
+		fields = append(fields, "TagNames: []string{"+strings.Join(quoted, ", ")+"}")
+	}
+	if o.NameMapper != nil {
+		mapper := ""
+		if reflect.TypeOf(o.NameMapper).Comparable() {
+			switch o.NameMapper {
+			case SnakeCase:
+				mapper = "SnakeCase"
+			case KebabCase:
+				mapper = "KebabCase"
+			case CamelCase:
+				mapper = "CamelCase"
+			case ScreamingSnakeCase:
+				mapper = "ScreamingSnakeCase"
+			}
+		}
+		if mapper == "" {
+			return "", fmt.Errorf("goloose: generated code can only use the built-in NameMappers")
+		}
+		fields = append(fields, "NameMapper: "+g.pkg+mapper)
+	}
+	return g.pkg + "Options{" + strings.Join(fields, ", ") + "}", nil
+}
+
+// file returns the complete source file.
+func (g *generator) file() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by goloose-gen. DO NOT EDIT.\n\npackage %s\n\n", g.cfg.PackageName)
+
+	paths := make([]string, 0, len(g.imports)+1)
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	if g.usesMath {
+		paths = append(paths, "math")
+	}
+	sort.Strings(paths)
+	var std, other []string
+	for _, path := range paths {
+		spec := strconv.Quote(path)
+		if name := g.imports[path]; name != "" && (name != importPathName(path) || !isStdImport(path) && path != golooseImportPath) {
+			// only std packages are sure to be called what their path says
+			spec = name + " " + spec
+		}
+		if isStdImport(path) {
+			std = append(std, spec)
+		} else {
+			other = append(other, spec)
+		}
+	}
+	if len(paths) > 0 {
+		buf.WriteString("import (\n")
+		for _, spec := range std {
+			buf.WriteString("\t" + spec + "\n")
+		}
+		if len(std) > 0 && len(other) > 0 {
+			buf.WriteString("\n")
+		}
+		for _, spec := range other {
+			buf.WriteString("\t" + spec + "\n")
+		}
+		buf.WriteString(")\n\n")
+	}
+
+	literal, _ := g.optionsLiteral()
+	fmt.Fprintf(&buf, "var %s = %s\n", g.options, literal)
+	buf.Write(g.body.Bytes())
+	return buf.Bytes()
+}
+
+func isStdImport(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// importPathName guesses the name of the package at path, which is the last element
+// of the path, or the one before that if the last one is a major version like v2.
+func importPathName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	return name
+}
+
+// importName imports path, and returns the name the file uses for it.
+func (g *generator) importName(path string) string {
+	if name, ok := g.imports[path]; ok {
+		return name
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, importPathName(path))
+	if !token.IsIdentifier(name) {
+		name = "pkg_" + name
+	}
+	name = g.declare(name)
+	g.imports[path] = name
+	return name
+}
+
+// typeName returns how the file refers to t, importing its package if needed,
+// or false if the file can't name it.
+func (g *generator) typeName(t reflect.Type) (string, bool) {
+	return g.typeString(t, true)
+}
+
+// canName reports whether the file can refer to t.
+func (g *generator) canName(t reflect.Type) bool {
+	_, ok := g.typeString(t, false)
+	return ok
+}
+
+func (g *generator) typeString(t reflect.Type, imports bool) (string, bool) {
+	if name := t.Name(); name != "" {
+		switch {
+		case strings.Contains(name, "["):
+			// the type arguments of generic types would need naming too
+			return "", false
+		case t.PkgPath() == "" || t.PkgPath() == g.cfg.PackagePath:
+			return name, true
+		case !token.IsExported(name):
+			return "", false
+		case !imports:
+			return name, true
+		}
+		return g.importName(t.PkgPath()) + "." + name, true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, ok := g.typeString(t.Elem(), imports)
+		return "*" + elem, ok
+	case reflect.Slice:
+		elem, ok := g.typeString(t.Elem(), imports)
+		return "[]" + elem, ok
+	case reflect.Array:
+		elem, ok := g.typeString(t.Elem(), imports)
+		return "[" + strconv.Itoa(t.Len()) + "]" + elem, ok
+	case reflect.Map:
+		key, ok := g.typeString(t.Key(), imports)
+		if !ok {
+			return "", false
+		}
+		elem, ok := g.typeString(t.Elem(), imports)
+		return "map[" + key + "]" + elem, ok
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return "any", true
+		}
+	}
+	return "", false
+}
+
+func (g *generator) line(format string, args ...any) {
+	if format == "}" || strings.HasPrefix(format, "} ") {
+		g.indent--
+	}
+	g.body.WriteString(strings.Repeat("\t", g.indent))
+	fmt.Fprintf(&g.body, format, args...)
+	g.body.WriteString("\n")
+	if strings.HasSuffix(format, "{") {
+		g.indent++
+	}
+}
+
+func (g *generator) writePair(pair GeneratePair) error {
+	if _, err := g.optionsLiteral(); err != nil {
+		return err
+	}
+	inName, ok := g.typeName(pair.In)
+	if !ok {
+		return fmt.Errorf("goloose: %s: can't refer to %v from package %s", pair.Name, pair.In, g.cfg.PackagePath)
+	}
+	outName, ok := g.typeName(pair.Out)
+	if !ok {
+		return fmt.Errorf("goloose: %s: can't refer to %v from package %s", pair.Name, pair.Out, g.cfg.PackagePath)
+	}
+	g.line("")
+	g.line("// %s converts *in into *out like %sToStruct(in, out, %s).", pair.Name, g.pkg, g.options)
+	g.line("func %s(in *%s, out *%s) error {", pair.Name, inName, outName)
+	if g.direct(reflect.PointerTo(pair.In), reflect.PointerTo(pair.Out)) && g.structOK(pair.In, pair.Out) {
+		g.line("if in == nil {")
+		if g.cfg.Options.Reset {
+			// which leaves unexported fields alone
+			g.line("return %sToStruct(in, out, %s)", g.pkg, g.options)
+		} else {
+			g.line("return nil")
+		}
+		g.line("}")
+		if g.cfg.Options.Reset {
+			// the helpers merge into what's there
+			g.line("*out = %s{}", outName)
+		}
+		g.line("return %s(in, out, 0)", g.helper(pair.In, pair.Out))
+	} else {
+		g.line("return %sToStruct(in, out, %s)", g.pkg, g.options)
+	}
+	g.line("}")
+	return nil
+}
+
+// helper returns the name of the function that converts in structs into out structs, writing it later if needed.
+func (g *generator) helper(in, out reflect.Type) string {
+	pair := typePair{in, out}
+	if name, ok := g.helpers[pair]; ok {
+		return name
+	}
+	name := g.declare(g.prefix + upperFirst(in.Name()) + "To" + upperFirst(out.Name()))
+	g.helpers[pair] = name
+	g.pending = append(g.pending, pair)
+	return name
+}
+
+func (g *generator) writeHelper(pair typePair) {
+	inName, _ := g.typeName(pair.in)
+	outName, _ := g.typeName(pair.out)
+
+	// write the fields first, to find out whether they follow pointers
+	body := g.body
+	g.body = bytes.Buffer{}
+	g.indent = 1
+	g.recursive = false
+	for _, fp := range cachedPlan(pair.in, pair.out, g.cfg.Options).fields {
+		g.writeField(pair, fp)
+	}
+	fields := g.body
+	g.body = body
+	g.indent = 0
+
+	g.line("")
+	g.line("func %s(in *%s, out *%s, depth int) error {", g.helpers[pair], inName, outName)
+	g.line("s, err := %sStartGenerated(out, %s, depth)", g.pkg, g.options)
+	g.line("if err != nil {")
+	g.line("return err")
+	g.line("}")
+	if g.recursive {
+		// this could be going around a cycle
+		g.line("if deep, err := s.ConvertDeep(in, out); deep {")
+		g.line("return err")
+		g.line("}")
+	}
+	g.body.Write(fields.Bytes())
+	g.line("return s.Done(nil)")
+	g.line("}")
+}
+
+// direct reports whether toStructImpl converts between inType and outType by their kinds,
+// rather than with a converter, GolooseValuer, GolooseValueSetter or JSON method.
+func (g *generator) direct(inType, outType reflect.Type) bool {
+	// toStructImpl follows output pointers, then input ones
+	for {
+		p := cachedPlan(inType, outType, g.cfg.Options)
+		if p.converter || p.goloose || p.fastPath || p.customJson {
+			return false
+		}
+		if outType.Kind() == reflect.Ptr {
+			outType = outType.Elem()
+		} else if inType.Kind() == reflect.Ptr {
+			inType = inType.Elem()
+		} else {
+			return true
+		}
+	}
+}
+
+// structOK reports whether a helper function can convert in structs into out structs.
+func (g *generator) structOK(in, out reflect.Type) bool {
+	if in.Kind() != reflect.Struct || out.Kind() != reflect.Struct || !g.canName(in) || !g.canName(out) {
+		return false
+	}
+	for _, fp := range cachedPlan(in, out, g.cfg.Options).fields {
+		if fp.out == nil && (!g.cfg.Options.DisallowUnknownFields || fp.shadowed) {
+			continue
+		}
+		_, inType, _, ok := g.fieldPath("in", in, fp.in.index, false)
+		if !ok {
+			return false
+		}
+		if fp.out == nil {
+			continue
+		}
+		_, _, allocs, ok := g.fieldPath("out", out, fp.out.index, true)
+		if !ok || len(allocs) > 0 && inType.Kind() == reflect.Interface {
+			// the output's embedded pointers are only allocated if the interface isn't a nil pointer
+			return false
+		}
+	}
+	return true
+}
+
+// An embeddedPtr is an embedded pointer on the way to a field.
+type embeddedPtr struct {
+	expr string
+	typ  reflect.Type
+}
+
+// fieldPath returns the expression for the field of root (of type t) at index and its type, along
+// with the embedded pointers on the way there, or false if the file can't get to it. If alloc is true
+// it's an output field, and those pointers have to be allocated.
+func (g *generator) fieldPath(root string, t reflect.Type, index []int, alloc bool) (string, reflect.Type, []embeddedPtr, bool) {
+	expr := root
+	var ptrs []embeddedPtr
+	for i, fieldIndex := range index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		sf := t.Field(fieldIndex)
+		if !sf.IsExported() && sf.PkgPath != g.cfg.PackagePath {
+			return "", nil, nil, false
+		}
+		expr += "." + sf.Name
+		if i < len(index)-1 && sf.Type.Kind() == reflect.Ptr {
+			if alloc && (!sf.IsExported() || !g.canName(sf.Type.Elem())) {
+				// toStructImpl can't allocate unexported embedded pointers either
+				return "", nil, nil, false
+			}
+			ptrs = append(ptrs, embeddedPtr{expr, sf.Type})
+		}
+		t = sf.Type
+	}
+	return expr, t, ptrs, true
+}
+
+func (g *generator) writeField(pair typePair, fp fieldPlan) {
+	f := fp.in
+	if fp.out == nil && (!g.cfg.Options.DisallowUnknownFields || fp.shadowed) {
+		return
+	}
+	// field.typ leaves out the pointer of unnamed pointer types
+	in, inType, guards, _ := g.fieldPath("in", pair.in, f.index, false)
+	opened := 0
+	open := func(cond string) {
+		if cond != "" {
+			g.line("if %s {", cond)
+			opened++
+		}
+	}
+	var conds []string
+	for _, guard := range guards {
+		conds = append(conds, guard.expr+" != nil")
+	}
+	open(strings.Join(conds, " && "))
+	if f.omitEmpty {
+		open(g.notEmpty(in, inType))
+	}
+	if f.omitZero {
+		open(g.notZero(in, inType))
+	}
+
+	if fp.out == nil {
+		g.line("s.Unknown(%q)", f.name)
+	} else {
+		if inType.Kind() == reflect.Ptr && !f.omitEmpty && !(f.omitZero && isZeroFunc(inType) == nil) {
+			// like json.Marshal, nil is null, which doesn't change anything
+			open(in + " != nil")
+		}
+		out, outType, allocs, _ := g.fieldPath("out", pair.out, fp.out.index, true)
+		for _, alloc := range allocs {
+			g.allocate(alloc.expr, alloc.typ)
+		}
+		// like toStructImpl, ",string" only matters when one of the fields has it
+		method := "Field"
+		switch {
+		case f.quoted && !fp.out.quoted:
+			method = "QuoteField"
+		case fp.out.quoted && !f.quoted && inType.Kind() == reflect.String:
+			method = "UnquoteField"
+		}
+		if method != "Field" || !g.convertValue(in, inType, out, outType, fp.out.name) {
+			g.line("if err := s.%s(&%s, &%s, %q); err != nil {", method, in, out, fp.out.name)
+			g.line("return s.Done(err)")
+			g.line("}")
+		}
+	}
+	for ; opened > 0; opened-- {
+		g.line("}")
+	}
+}
+
+// allocate writes code that points the pointer expr (of type t) to a new value if it's nil.
+func (g *generator) allocate(expr string, t reflect.Type) {
+	elem, _ := g.typeName(t.Elem())
+	g.line("if %s == nil {", expr)
+	g.line("%s = new(%s)", expr, elem)
+	g.line("}")
+}
+
+// notEmpty returns the condition under which expr, of type t, isn't empty
+// for the "omitempty" option, or "" if it never is.
+func (g *generator) notEmpty(expr string, t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return "len(" + expr + ") != 0"
+	case reflect.Bool:
+		return expr
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return expr + " != 0"
+	case reflect.Interface, reflect.Ptr:
+		return expr + " != nil"
+	}
+	return ""
+}
+
+// notZero returns the condition under which expr, of type t, isn't zero for the "omitzero" option.
+func (g *generator) notZero(expr string, t reflect.Type) string {
+	if isZeroFunc(t) != nil {
+		switch {
+		case t.Kind() == reflect.Interface:
+			// this needs to look inside the interface
+		case t.Kind() == reflect.Ptr && t.Implements(isZeroerType):
+			return expr + " != nil && !" + expr + ".IsZero()"
+		default:
+			// expr is addressable, so this works for pointer methods too
+			return "!" + expr + ".IsZero()"
+		}
+		return "!s.IsZero(&" + expr + ")"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return expr
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return expr + " != 0"
+	case reflect.Float32, reflect.Float64:
+		// -0 isn't zero
+		g.usesMath = true
+		return "math.Float64bits(float64(" + expr + ")) != 0"
+	case reflect.String:
+		return expr + ` != ""`
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return expr + " != nil"
+	}
+	return "!s.IsZero(&" + expr + ")"
+}
+
+// convertValue writes code that converts in, of type inType, into out, of type outType, the
+// output field called name, and reports whether it could. If in is a pointer it isn't nil.
+func (g *generator) convertValue(in string, inType reflect.Type, out string, outType reflect.Type, name string) bool {
+	if !g.direct(inType, outType) {
+		return false
+	}
+	inVal, inAddr := in, "&"+in
+	inPtr := inType.Kind() == reflect.Ptr
+	if inPtr {
+		inType = inType.Elem()
+		inVal, inAddr = "*"+in, in
+		if inType.Kind() == reflect.Ptr {
+			// toStructImpl allocates the output before it finds out whether this is nil
+			return false
+		}
+	}
+	outVal, outAddr := out, "&"+out
+	var allocs []string
+	var allocTypes []reflect.Type
+	for outType.Kind() == reflect.Ptr {
+		if !g.canName(outType.Elem()) {
+			return false
+		}
+		allocs = append(allocs, outVal)
+		allocTypes = append(allocTypes, outType)
+		outVal, outAddr = "*"+outVal, outVal
+		outType = outType.Elem()
+	}
+	writeAllocs := func() {
+		for i, alloc := range allocs {
+			g.allocate(alloc, allocTypes[i])
+		}
+	}
+
+	switch {
+	case g.structOK(inType, outType):
+		g.recursive = g.recursive || inPtr
+		writeAllocs()
+		g.line("if err := s.Nested(%s(%s, %s, depth+1), %q); err != nil {", g.helper(inType, outType), inAddr, outAddr, name)
+		g.line("return s.Done(err)")
+		g.line("}")
+		return true
+
+	case inType.Kind() == reflect.Slice && outType.Kind() == reflect.Slice:
+		if len(allocs) > 0 {
+			// a nil slice would clear the pointer instead
+			return false
+		}
+		if !g.direct(inType.Elem(), outType.Elem()) || !g.canName(outType) {
+			return false
+		}
+		if g.structOK(inType.Elem(), outType.Elem()) {
+			g.convertStructSlice(inVal, inType, outVal, outType, name)
+			return true
+		}
+		ok, checkFloat := g.scalarOK(inType.Elem(), outType.Elem())
+		if !ok || checkFloat || isBinary(inType, g.cfg.Options) && outType.Elem().Kind() != reflect.Uint8 {
+			return false
+		}
+		outName, _ := g.typeName(outType)
+		g.line("if %s == nil {", inVal)
+		g.line("%s = nil", outVal)
+		g.line("} else {")
+		g.line("if %s == nil || len(%s) != len(%s) {", outVal, outVal, inVal)
+		g.line("%s = make(%s, len(%s), cap(%s))", outVal, outName, inVal, inVal)
+		g.line("}")
+		if inType.Elem() == outType.Elem() {
+			g.line("copy(%s, %s)", outVal, inVal)
+		} else {
+			g.line("for i, v := range %s {", inVal)
+			g.line("%s[i] = %s", parens(outVal), g.conversion("v", inType.Elem(), outType.Elem()))
+			g.line("}")
+		}
+		g.line("}")
+		return true
+
+	case inType.Kind() == reflect.Map && outType.Kind() == reflect.Map:
+		if len(allocs) > 0 || !g.structMapOK(inType, outType) {
+			return false
+		}
+		g.convertStructMap(inVal, inType, outVal, outType, name)
+		return true
+	}
+
+	ok, checkFloat := g.scalarOK(inType, outType)
+	if !ok {
+		return false
+	}
+	writeAllocs()
+	if checkFloat {
+		g.usesMath = true
+		g.line("if math.IsNaN(float64(%s)) || math.IsInf(float64(%s), 0) {", inVal, inVal)
+		g.line("if err := s.UnsupportedFloat(%s, %q); err != nil {", inVal, name)
+		g.line("return s.Done(err)")
+		g.line("}")
+		g.line("} else {")
+	}
+	g.line("%s = %s", outVal, g.conversion(inVal, inType, outType))
+	if checkFloat {
+		g.line("}")
+	}
+	return true
+}
+
+// convertStructSlice writes code that converts in, a slice of structs, into out, a slice of structs
+// that a helper function can convert them into, the way toStructImpl does.
+func (g *generator) convertStructSlice(in string, inType reflect.Type, out string, outType reflect.Type, name string) {
+	outName, _ := g.typeName(outType)
+	g.line("if %s == nil {", in)
+	g.line("%s = nil", out)
+	g.line("} else {")
+	if g.cfg.Options.Reset {
+		// like toStructImpl, reuse the backing array
+		g.line("if %s != nil && cap(%s) >= len(%s) {", out, out, in)
+		g.line("%s = %s[:len(%s)]", out, parens(out), in)
+		g.line("} else if %s == nil || len(%s) != len(%s) {", out, out, in)
+	} else {
+		g.line("if %s == nil || len(%s) != len(%s) {", out, out, in)
+	}
+	g.line("%s = make(%s, len(%s), cap(%s))", out, outName, in, in)
+	g.line("}")
+	g.line("for i := range %s {", in)
+	g.line("if err := s.Index(%s(&%s[i], &%s[i], depth+2), %q, i); err != nil {",
+		g.helper(inType.Elem(), outType.Elem()), parens(in), parens(out), name)
+	g.line("return s.Done(err)")
+	g.line("}")
+	g.line("}")
+	g.line("}")
+}
+
+// structMapOK reports whether convertStructMap can convert maps of inType into maps of outType.
+func (g *generator) structMapOK(inType, outType reflect.Type) bool {
+	key := inType.Key()
+	if g.cfg.Options.CollectErrors {
+		// the errors would have to be in the order of the sorted keys
+		return false
+	}
+	if key != outType.Key() || key.Kind() != reflect.String || !g.canName(outType) ||
+		key.Implements(textMarshalerType) || reflect.PointerTo(key).Implements(textUnmarshalerType) {
+		return false
+	}
+	return g.direct(inType.Elem(), outType.Elem()) && g.structOK(inType.Elem(), outType.Elem())
+}
+
+// convertStructMap writes code that converts in, a map of structs, into out, a map of structs
+// with the same keys that a helper function can convert them into, the way toStructImpl does.
+func (g *generator) convertStructMap(in string, inType reflect.Type, out string, outType reflect.Type, name string) {
+	outName, _ := g.typeName(outType)
+	elemName, _ := g.typeName(outType.Elem())
+	g.line("if %s == nil {", in)
+	g.line("%s = nil", out)
+	g.line("} else {")
+	if g.cfg.Options.Reset {
+		g.line("clear(%s)", out)
+	}
+	g.line("for k, v := range %s {", in)
+	g.line("var elem %s", elemName)
+	g.line("set, err := s.Entry(%s(&v, &elem, depth+2), %q, %s)",
+		g.helper(inType.Elem(), outType.Elem()), name, g.conversion("k", inType.Key(), reflect.TypeFor[string]()))
+	g.line("if set {")
+	g.line("if %s == nil {", out)
+	g.line("%s = make(%s, len(%s))", out, outName, in)
+	g.line("}")
+	g.line("%s[k] = elem", parens(out))
+	g.line("}")
+	g.line("if err != nil {")
+	g.line("return s.Done(err)")
+	g.line("}")
+	g.line("}")
+	g.line("if %s == nil {", out)
+	g.line("// like json.Unmarshal, an empty object still makes a map")
+	g.line("%s = %s{}", out, outName)
+	g.line("}")
+	g.line("}")
+}
+
+func parens(expr string) string {
+	if strings.HasPrefix(expr, "*") {
+		return "(" + expr + ")"
+	}
+	return expr
+}
+
+// conversion returns expr, of type inType, converted to outType.
+func (g *generator) conversion(expr string, inType, outType reflect.Type) string {
+	if inType == outType {
+		return expr
+	}
+	outName, _ := g.typeName(outType)
+	return outName + "(" + expr + ")"
+}
+
+// scalarOK reports whether converting an inType into an outType is a Go conversion, and whether
+// that's only true if the value isn't a NaN or infinity, which json.Marshal rejects.
+func (g *generator) scalarOK(inType, outType reflect.Type) (ok, checkFloat bool) {
+	if inType == numberType || outType == numberType || inType != outType && !g.canName(outType) {
+		return false, false
+	}
+	inBits, inSigned, inKind := scalarRange(inType.Kind())
+	outBits, outSigned, outKind := scalarRange(outType.Kind())
+	switch {
+	case inKind == reflect.Invalid || outKind == reflect.Invalid:
+		return false, false
+	case inType.Kind() == outType.Kind():
+		return true, inKind == reflect.Float64
+	case inKind == reflect.Float64:
+		// floats of other sizes go through their JSON representation
+		return false, false
+	case outKind == reflect.Float64:
+		return inKind == reflect.Int, false
+	case inKind == reflect.Int:
+		// the integer has to fit however big int is, like uint32 into int
+		return outKind == reflect.Int && (inSigned == outSigned && inBits[1] <= outBits[0] ||
+			!inSigned && outSigned && inBits[1] < outBits[0]), false
+	}
+	return inKind == outKind, false
+}
+
+// scalarRange groups the scalar kinds together, returning the range of sizes of the integer kinds.
+func scalarRange(kind reflect.Kind) (bits [2]int, signed bool, group reflect.Kind) {
+	switch kind {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size := 8 << (kind - reflect.Int8)
+		return [2]int{size, size}, true, reflect.Int
+	case reflect.Int:
+		return [2]int{32, 64}, true, reflect.Int
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size := 8 << (kind - reflect.Uint8)
+		return [2]int{size, size}, false, reflect.Int
+	case reflect.Uint, reflect.Uintptr:
+		return [2]int{32, 64}, false, reflect.Int
+	case reflect.Float32, reflect.Float64:
+		return bits, false, reflect.Float64
+	case reflect.Bool, reflect.String:
+		return bits, false, kind
+	}
+	return bits, false, reflect.Invalid
+}
@@ -0,0 +1,125 @@
+package goloose
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+)
+
+// generatedStruct keeps track of converting one struct into another for the code that
+// cmd/goloose-gen generates, which uses it for the fields it can't convert by itself.
+type generatedStruct struct {
+	outType reflect.Type
+	options Options
+	depth   int
+	unknown []string
+	saved   []error
+}
+
+// StartGenerated starts converting into the struct that out points to, depth levels below
+// the value passed to a function generated by cmd/goloose-gen.
+//
+// It's the only part of goloose that generated code uses, and isn't meant to be called otherwise.
+// It isn't covered by goloose's compatibility promise: it may change in any release, along with
+// the code goloose-gen generates.
+func StartGenerated(out any, options Options, depth int) (generatedStruct, error) {
+	if depth > options.maxDepth() {
+		return generatedStruct{}, ErrMaxDepth
+	}
+	return generatedStruct{outType: reflect.TypeOf(out).Elem(), options: options, depth: depth}, nil
+}
+
+// ConvertDeep converts *in into *out like ToStruct if the conversion is deep enough into the input that it
+// might have a cycle, which generated functions don't keep track of themselves, and reports whether it did.
+func (s *generatedStruct) ConvertDeep(in, out any) (bool, error) {
+	if s.depth <= startDetectingCyclesAfter {
+		return false, nil
+	}
+	return true, toStructImpl(reflect.ValueOf(in).Elem(), reflect.ValueOf(out).Elem(), s.options, recursion{level: s.depth - 1}.next())
+}
+
+// Field converts *in, an input struct field, into *out, the output struct field called name,
+// the way ToStruct does. It returns an error if the conversion should stop.
+func (s *generatedStruct) Field(in, out any, name string) error {
+	return s.field(reflect.ValueOf(in).Elem(), out, name)
+}
+
+// QuoteField is like Field for an input field with the ",string" option going into
+// an output field without it, which gets the string the input field is encoded as.
+func (s *generatedStruct) QuoteField(in, out any, name string) error {
+	return s.field(quote(reflect.ValueOf(in).Elem()), out, name)
+}
+
+// UnquoteField is like Field for a string going into an output field with the ",string" option,
+// which gets the value the string decodes to.
+func (s *generatedStruct) UnquoteField(in, out any, name string) error {
+	return s.field(dequote(reflect.ValueOf(in).Elem()), out, name)
+}
+
+func (s *generatedStruct) field(val reflect.Value, out any, name string) error {
+	if val.Kind() == reflect.Interface {
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		return nil
+	}
+	return s.Nested(toStructImpl(val, reflect.ValueOf(out).Elem(), s.options, recursion{level: s.depth}.next()), name)
+}
+
+// UnsupportedFloat records that v, the float in an input field, is a NaN or infinity,
+// which json.Marshal rejects. name is the output field it was going into.
+func (s *generatedStruct) UnsupportedFloat(v any, name string) error {
+	return s.Nested(unsupportedFloatError(reflect.ValueOf(v)), name)
+}
+
+// Nested records err, from converting into the output struct field called name with
+// another generated function. It returns an error if the conversion should stop.
+func (s *generatedStruct) Nested(err error, name string) error {
+	if err == nil {
+		return nil
+	}
+	return saveError(&s.saved, s.options, addErrorContext(err, s.outType, name))
+}
+
+// Index records err, from converting into element i of the output slice field called name
+// with another generated function. It returns an error if the conversion should stop.
+func (s *generatedStruct) Index(err error, name string, i int) error {
+	return s.Nested(addErrorContext(err, nil, strconv.Itoa(i)), name)
+}
+
+// Entry is like Index for the entry with the key key of an output map field, and
+// also reports whether to set the entry, which like toStructImpl it doesn't if
+// converting into it failed in a way that skips the value.
+func (s *generatedStruct) Entry(err error, name, key string) (bool, error) {
+	var skipErr *skipValError
+	set := !errors.As(err, &skipErr)
+	return set, s.Nested(addErrorContext(err, nil, key), name)
+}
+
+// Unknown records an input field that doesn't match any output field,
+// for Options.DisallowUnknownFields.
+func (s *generatedStruct) Unknown(name string) {
+	s.unknown = append(s.unknown, name)
+}
+
+// IsZero reports whether *v is zero for the "omitzero" option.
+func (s *generatedStruct) IsZero(v any) bool {
+	val := reflect.ValueOf(v).Elem()
+	if isZero := isZeroFunc(val.Type()); isZero != nil {
+		return isZero(val)
+	}
+	return val.IsZero()
+}
+
+// Done finishes the conversion, returning err if it stopped early or the errors it recorded otherwise.
+func (s *generatedStruct) Done(err error) error {
+	if err == nil {
+		if err = saveError(&s.saved, s.options, unknownFieldsError(s.unknown)); err == nil {
+			err = joinErrors(s.saved)
+		}
+	}
+	if s.depth == 0 {
+		return unwrapSkipValError(err)
+	}
+	return err
+}
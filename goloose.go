@@ -1,19 +1,45 @@
 package goloose
 
 import (
+	"bytes"
 	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
+	"unsafe"
 )
 
 type Options struct {
 	StringToFloat64 bool // controls whether goloose will convert strings to floats if required; note this breaks the json.Unmarshal paradigm
 
+	UseNumber        bool // like json.Decoder.UseNumber, numbers converted into an interface{} become json.Numbers instead of float64s
+	PreserveIntegers bool // integers converted into an interface{} become int64s or uint64s instead of float64s, so they don't lose precision; UseNumber takes priority
+
+	DisallowUnknownFields bool // like json.Decoder.DisallowUnknownFields, report an ErrUnknownField for every input field that doesn't match a field in an output struct
+	CollectErrors         bool // keep converting after an error, and return every error joined together; json.Unmarshal stops at most errors and only reports the first
+
+	CaseSensitive bool // only match names to struct fields exactly, like encoding/json/v2; otherwise an exact match is preferred, then a case-insensitive one
+
+	Semantics Semantics // which version of encoding/json to imitate, SemanticsV1 by default
+
+	TagNames   []string   // struct tags that control field names, omitempty and "-", in order of preference; a field uses the first of them it has. Defaults to json
+	NameMapper NameMapper // names fields that don't get a name from their tag, like SnakeCase; by default they use their Go names
+
+	Converters []TypeConverter // convert values of particular types directly, see ConverterFunc; these take priority over RegisterConverter
+
+	Reset bool // make the result exactly what converting into a zero value would give, apart from unexported fields, instead of merging into out like json.Unmarshal; out's maps, slices and pointers are reused where they can be
+
+	MaxDepth int // how deeply nested the input can be before goloose gives up with ErrMaxDepth, 10000 by default
+
 	Transforms []TransformFunc
 }
 type TransformFunc func(interface{}) interface{}
@@ -41,6 +67,7 @@ func ConvertTo[T any](in any, options ...Options) (T, error) {
 //
 // Note: the semantics for doing this on a nonzero "out" can be surprising,
 // see https://pkg.go.dev/encoding/json#Unmarshal for some details of the behaviour.
+// Options.Reset makes it the same as converting into a zero value instead.
 func ToStruct(in, out interface{}, options ...Options) error {
 	var opt Options
 	if len(options) > 1 {
@@ -48,20 +75,64 @@ func ToStruct(in, out interface{}, options ...Options) error {
 	} else if len(options) == 1 {
 		opt = options[0]
 	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
 
 	inVal := reflect.ValueOf(in)
-	if isNil(inVal) {
+	outVal := reflect.ValueOf(out)
+	if isNull(inVal, opt) {
+		if opt.Reset && outVal.Kind() == reflect.Ptr && !outVal.IsNil() {
+			resetNull(outVal.Elem())
+		}
 		return nil
 	}
-	outVal := reflect.ValueOf(out)
 	if outVal.Kind() != reflect.Ptr {
 		return fmt.Errorf("non-pointer type %T passed to ToStruct", out)
 	}
+	return convert(inVal, outVal, opt)
+}
 
-	err := toStructImpl(inVal, outVal, opt, 0)
+// validate returns an error if options can't be used.
+func (options Options) validate() error {
+	switch options.Semantics {
+	case SemanticsV1:
+	case SemanticsV2:
+		if !jsonv2Available {
+			return errJSONv2Unavailable
+		}
+	default:
+		return fmt.Errorf("goloose: unknown Semantics %d", options.Semantics)
+	}
+	if options.MaxDepth < 0 {
+		return fmt.Errorf("goloose: negative MaxDepth %d", options.MaxDepth)
+	}
+	for _, name := range options.TagNames {
+		if name == "" || strings.ContainsAny(name, " :\"") {
+			return fmt.Errorf("goloose: invalid tag name %q", name)
+		}
+	}
+	for _, c := range options.Converters {
+		if c.convert == nil {
+			return errors.New("goloose: TypeConverters have to be made with ConverterFunc")
+		}
+	}
+	return nil
+}
+
+// convert converts inVal into the value outVal points to, once the options have been validated
+// and null inputs have been skipped.
+func convert(inVal, outVal reflect.Value, opt Options) error {
+	return unwrapSkipValError(toStructImpl(inVal, outVal, opt, recursion{}))
+}
+
+// unwrapSkipValError removes the skipValErrors wrapping err, which are internal.
+func unwrapSkipValError(err error) error {
+	if err == nil {
+		return nil
+	}
 	var skipValError *skipValError
 	for errors.As(err, &skipValError) {
-		// this is internal, unwrap it for the caller
 		err = skipValError.err
 	}
 	return err
@@ -82,12 +153,12 @@ func fastPathMapStringAny(in, out any, opt Options) (handled bool) {
 				return true
 			case map[string]float64:
 				outMap := make(map[string]any, len(in))
-				fastPathMapStringAnyImpl(in, &outMap, opt.Transforms)
+				fastPathMapStringAnyImpl(in, &outMap, floatTransforms(opt))
 				*out = outMap
 				return true
 			case map[string]int:
 				outMap := make(map[string]any, len(in))
-				fastPathMapStringAnyImpl(in, &outMap, append(opt.Transforms, intToFloat64Transform))
+				fastPathMapStringAnyImpl(in, &outMap, intTransforms(opt))
 				*out = outMap
 				return true
 			}
@@ -98,16 +169,35 @@ func fastPathMapStringAny(in, out any, opt Options) (handled bool) {
 			fastPathMapStringAnyImpl(in, out, opt.Transforms)
 			return true
 		case map[string]float64:
-			fastPathMapStringAnyImpl(in, out, opt.Transforms)
+			fastPathMapStringAnyImpl(in, out, floatTransforms(opt))
 			return true
 		case map[string]int:
-			fastPathMapStringAnyImpl(in, out, append(opt.Transforms, intToFloat64Transform))
+			fastPathMapStringAnyImpl(in, out, intTransforms(opt))
 			return true
 		}
 	}
 	return false
 }
 
+// intTransforms returns the transforms to apply to ints on the fast path,
+// converting them to whatever toJsonType would.
+func intTransforms(opt Options) []TransformFunc {
+	switch {
+	case opt.UseNumber:
+		return append(opt.Transforms, intToNumberTransform)
+	case opt.PreserveIntegers:
+		return append(opt.Transforms, intToInt64Transform)
+	}
+	return append(opt.Transforms, intToFloat64Transform)
+}
+
+func floatTransforms(opt Options) []TransformFunc {
+	if opt.UseNumber {
+		return append(opt.Transforms, float64ToNumberTransform)
+	}
+	return opt.Transforms
+}
+
 func fastPathMapStringAnyImpl[E string | float64 | int](in map[string]E, out *map[string]any, transforms []TransformFunc) {
 	if *out == nil && in != nil {
 		*out = make(map[string]any, len(in))
@@ -122,14 +212,123 @@ func fastPathMapStringAnyImpl[E string | float64 | int](in map[string]E, out *ma
 }
 
 func intToFloat64Transform(i any) any {
-	return float64(i.(int))
+	if n, ok := i.(int); ok {
+		return float64(n)
+	}
+	return i
+}
+
+func intToInt64Transform(i any) any {
+	if n, ok := i.(int); ok {
+		return int64(n)
+	}
+	return i
+}
+
+func intToNumberTransform(i any) any {
+	if n, ok := i.(int); ok {
+		return json.Number(strconv.Itoa(n))
+	}
+	return i
+}
+
+func float64ToNumberTransform(i any) any {
+	if n, ok := i.(float64); ok {
+		return json.Number(formatFloat(n, 64))
+	}
+	return i
 }
 
-const maxRecursionLevel = 10000
+const (
+	defaultMaxDepth = 10000
 
-func toStructImpl(in, out reflect.Value, options Options, recursionLevel int) error {
-	if recursionLevel > maxRecursionLevel {
-		return fmt.Errorf("maximum recursion level reached, you likely have a pointer cycle in your data structure")
+	// Like encoding/json, only look for cycles once we're deep enough that there's likely to be one.
+	startDetectingCyclesAfter = 1000
+)
+
+// ErrMaxDepth is returned when the input is nested more deeply than Options.MaxDepth allows.
+var ErrMaxDepth = errors.New("maximum depth exceeded")
+
+// recursion tracks how deep toStructImpl is in its input, and once that's deep enough
+// that there might be a cycle, which pointers, maps and slices it's inside of.
+type recursion struct {
+	level int
+	seen  map[cycleKey]struct{}
+}
+
+type cycleKey struct {
+	typ reflect.Type
+	ptr unsafe.Pointer
+	len int // for slices, which can share a pointer with a shorter slice that isn't a cycle
+
+	// the same input goes into different outputs on the way down, like through output pointers
+	outType reflect.Type
+}
+
+// next returns the recursion one level deeper.
+func (rec recursion) next() recursion {
+	rec.level++
+	if rec.level > startDetectingCyclesAfter && rec.seen == nil {
+		rec.seen = map[cycleKey]struct{}{}
+	}
+	return rec
+}
+
+// visit records that toStructImpl is converting what in points to into an outType, if it's keeping
+// track, and returns the key to delete from rec.seen when it's done. It returns an error if toStructImpl
+// is already doing that further up, since then the input has a cycle, which json.Marshal rejects.
+func (rec recursion) visit(in reflect.Value, outType reflect.Type) (cycleKey, error) {
+	var key cycleKey
+	switch in.Kind() {
+	case reflect.Ptr, reflect.Map:
+		key = cycleKey{typ: in.Type(), ptr: in.UnsafePointer(), outType: outType}
+	case reflect.Slice:
+		key = cycleKey{typ: in.Type(), ptr: in.UnsafePointer(), len: in.Len(), outType: outType}
+	}
+	if key.ptr == nil {
+		return cycleKey{}, nil
+	}
+	if _, ok := rec.seen[key]; ok {
+		err := &PathError{Err: &json.UnsupportedValueError{Value: in, Str: "encountered a cycle via " + in.Type().String()}}
+		return key, &skipValError{err: &cycleError{path: err, key: key}}
+	}
+	rec.seen[key] = struct{}{}
+	return key, nil
+}
+
+// maxDepth returns the deepest toStructImpl will go.
+func (options Options) maxDepth() int {
+	if options.MaxDepth == 0 {
+		return defaultMaxDepth
+	}
+	return options.MaxDepth
+}
+
+func toStructImpl(in, out reflect.Value, options Options, rec recursion) error {
+	if rec.level > options.maxDepth() {
+		return ErrMaxDepth
+	}
+	if rec.seen == nil {
+		return toStructValue(in, out, options, rec)
+	}
+	key, err := rec.visit(in, out.Type())
+	if err != nil {
+		return err
+	}
+	err = toStructValue(in, out, options, rec)
+	delete(rec.seen, key)
+	if err != nil && key.ptr != nil {
+		startCycleLoops(err, key)
+	}
+	return err
+}
+
+// toStructValue does the work of toStructImpl, which keeps track of cycles around it.
+func toStructValue(in, out reflect.Value, options Options, rec recursion) error {
+	if !in.IsValid() && (options.Semantics == SemanticsV2 || options.Reset) && out.CanSet() {
+		// a nil interface is null, which v2 uses to clear everything
+		out.Set(reflect.Zero(out.Type()))
+		return nil
 	}
 	if !in.IsValid() || !in.CanInterface() {
 		return nil
@@ -141,247 +340,648 @@ func toStructImpl(in, out reflect.Value, options Options, recursionLevel int) er
 		}
 	}
 
-	if handled := fastPathMapStringAny(in.Interface(), out.Interface(), options); handled {
-		return nil
-	}
+	return toStructPlanned(in, out, cachedPlan(in.Type(), out.Type(), options), options, rec)
+}
 
+// toStructPlanned does the rest of toStructValue's work, with p, the plan for converting in's type into out's.
+func toStructPlanned(in, out reflect.Value, p *plan, options Options, rec recursion) error {
 	inType := in.Type()
 	outType := out.Type()
-	if handled, err := customJson(in, inType, out, outType); handled {
-		return err
+	if options.Reset {
+		resetOut(in, out, p)
+	}
+	if p.converter || len(options.Converters) > 0 {
+		if handled, err := convertWithConverter(in, out, options); handled {
+			return err
+		}
+	}
+	if p.goloose {
+		if handled, err := convertGolooseValue(in, out, options, rec); handled {
+			return err
+		}
+	}
+
+	// the fast path doesn't check for the invalid UTF-8 that v2 rejects
+	if p.fastPath && options.Semantics == SemanticsV1 && fastPathMapStringAny(in.Interface(), out.Interface(), options) {
+		return nil
+	}
+
+	if p.customJson {
+		if handled, err := customJson(in, inType, out, outType, options, rec); handled {
+			return err
+		}
 	}
 
 	if out.Kind() == reflect.Ptr {
-		if (in.Kind() == reflect.Ptr || in.Kind() == reflect.Interface) && in.IsNil() && out.CanAddr() {
+		if isNull(in, options) && out.CanAddr() {
 			out.Set(reflect.Zero(outType))
 			return nil
 		}
 		if out.IsNil() {
 			out.Set(reflect.New(outType.Elem()))
 		}
-		return toStructImpl(in, out.Elem(), options, recursionLevel+1)
+		return toStructImpl(in, out.Elem(), options, rec.next())
 	}
-	if isNil(in) {
-		out.Set(reflect.Zero(outType))
+	v2 := options.Semantics == SemanticsV2
+	caseSensitive := v2 || options.CaseSensitive
+	if isNull(in, options) {
+		// like json.Unmarshal, null only clears values that can be nil, but v2 clears everything
+		switch {
+		case v2, options.Reset, out.Kind() == reflect.Interface, out.Kind() == reflect.Map, out.Kind() == reflect.Slice:
+			out.Set(reflect.Zero(outType))
+		}
 		return nil
 	}
 	if out.Kind() == reflect.Interface {
-		if out.IsNil() {
-			var outVal reflect.Value
-
-			for inType.Kind() == reflect.Ptr {
-				if isNil(in) {
-					return nil
-				}
-				in = in.Elem()
-				inType = in.Type()
+		if !out.IsNil() && v2 {
+			// v2 converts into a copy of whatever the interface holds, and stores that back
+			elem := reflect.New(out.Elem().Type()).Elem()
+			elem.Set(out.Elem())
+			err := toStructImpl(in, elem, options, rec.next())
+			out.Set(elem)
+			return err
+		}
+		if !out.IsNil() {
+			// like json.Unmarshal, convert into a non-nil pointer held by the interface, and replace anything else
+			if elem := out.Elem(); elem.Kind() == reflect.Ptr && !elem.IsNil() {
+				return toStructImpl(in, elem, options, rec.next())
 			}
+			out.Set(reflect.Zero(outType))
+		}
+		var outVal reflect.Value
+
+		for inType.Kind() == reflect.Ptr {
 			if isNil(in) {
 				return nil
 			}
-			inType = toJsonType(inType)
-			switch inType.Kind() {
-			case reflect.Struct, reflect.Map:
-				outVal = reflect.MakeMap(mapStringInterfaceType)
-				err := toStructImpl(in, outVal, options, recursionLevel+1)
-				var skipErr *skipValError
-				if !errors.As(err, &skipErr) {
-					out.Set(outVal)
-				}
-				return err
-			case reflect.Slice:
-				outVal = reflect.New(interfaceSliceType)
-			case reflect.Interface:
-				return toStructImpl(in.Elem(), out, options, recursionLevel+1)
-			default:
-				outVal = reflect.New(inType).Elem()
-				err := toStructImpl(in, outVal, options, recursionLevel+1)
-				if err != nil {
-					return err
-				}
+			in = in.Elem()
+			inType = in.Type()
+		}
+		if isNil(in) && !v2 {
+			return nil
+		}
+		if outType.NumMethod() != 0 && inType.Kind() != reflect.Interface {
+			// json.Unmarshal can't pick a concrete type for this
+			return &json.UnmarshalTypeError{Value: jsonValueKind(inType), Type: outType}
+		}
+		inType = toJsonType(inType, options)
+		switch inType.Kind() {
+		case reflect.Struct, reflect.Map:
+			outVal = reflect.MakeMap(mapStringInterfaceType)
+			err := toStructImpl(in, outVal, options, rec.next())
+			var skipErr *skipValError
+			if !errors.As(err, &skipErr) {
 				out.Set(outVal)
-				return nil
 			}
-			err := toStructImpl(in, outVal, options, recursionLevel+1)
-			out.Set(outVal.Elem())
 			return err
+		case reflect.Slice, reflect.Array:
+			if isBinary(inType, options) {
+				// encoding/json encodes byte slices (and v2 byte arrays) as base64 strings
+				out.Set(reflect.ValueOf(base64.StdEncoding.EncodeToString(binaryBytes(in))))
+				return nil
+			}
+			outVal = reflect.New(interfaceSliceType)
+		case reflect.Interface:
+			return toStructImpl(in.Elem(), out, options, rec.next())
+		default:
+			outVal = reflect.New(inType).Elem()
+			err := toStructImpl(in, outVal, options, rec.next())
+			if err != nil {
+				return err
+			}
+			out.Set(outVal)
+			return nil
 		}
-		// it would be nice to handle this more performantly, but there are some edge cases that need to be considered more thoroughly!
-		return toStructSlow(in.Interface(), out.Addr().Interface())
+		err := toStructImpl(in, outVal, options, rec.next())
+		out.Set(outVal.Elem())
+		return err
 	}
-	var outFields []field
+	var outFields structFields
+	var unknownFields []string
+	var savedErrs []error
+	var written [][]int // the indexes of the output struct fields converted into, for Options.Reset
 
 	switch in.Kind() {
 	case reflect.Struct:
 		if out.Kind() != reflect.Map && out.Kind() != reflect.Struct {
 			return nil
 		}
-		fields := cachedTypeFields(inType)
-		for _, field := range fields {
-			val := fieldByIndex(in, field.index, false)
-			if field.omitEmpty && isEmptyValue(val) {
+		for _, fieldPlan := range p.fields {
+			field := fieldPlan.in
+			val, ok := fieldByIndexNoAlloc(in, field.index)
+			if !ok {
+				// like json.Marshal, skip fields of nil embedded structs
+				continue
+			}
+			if field.omitEmpty && (!v2 && isEmptyValue(val) || v2 && isEmptyValueV2(val, options)) {
 				continue
 			}
-			if field.quoted {
-				val = dequote(val)
+			if field.omitZero && (field.isZero == nil && val.IsZero() || field.isZero != nil && field.isZero(val)) {
+				continue
 			}
+			raw := val
 			if val.Kind() == reflect.Interface {
 				val = val.Elem()
 			}
 			switch out.Kind() {
 			case reflect.Map:
-				outVal := reflect.New(outType.Elem())
-				err := toStructImpl(val, outVal, options, recursionLevel+1)
+				if field.quoted {
+					// like json.Marshal, the map gets the string the field is encoded as
+					val = quote(raw)
+				}
+				outVal := newMapValue(out, field.name, options)
+				err := toStructImpl(val, outVal, options, rec.next())
 				var skipErr *skipValError
 				if !errors.As(err, &skipErr) {
 					if out.IsNil() {
 						outMap := reflect.MakeMap(outType)
 						out.Set(outMap)
 					}
-					nameVal := reflect.ValueOf(string(field.name)).Convert(outType.Key())
+					nameVal, keyErr := mapKey(field.name, outType.Key(), options)
+					if keyErr != nil {
+						if keyErr = saveError(&savedErrs, options, keyErr); keyErr != nil {
+							return keyErr
+						}
+						continue
+					}
 					out.SetMapIndex(nameVal, outVal.Elem())
 				}
-				if err != nil {
+				if err := saveError(&savedErrs, options, addErrorContext(err, nil, field.name)); err != nil {
 					return err
 				}
 			case reflect.Struct:
-				if len(outFields) == 0 {
-					outFields = cachedTypeFields(outType)
-				}
-				for _, outfield := range outFields {
-					if outfield.namelower == field.namelower {
-						if field.quoted {
-							val = dequote(val)
-						}
-						if val.Kind() == reflect.Ptr && val.IsNil() {
-							continue
-						}
-						err := toStructImpl(val, fieldByIndex(out, outfield.index, true), options, recursionLevel+1)
-						if err != nil {
-							return err
-						}
+				outfield := fieldPlan.out
+				if outfield == nil {
+					if options.DisallowUnknownFields && !fieldPlan.shadowed {
+						unknownFields = append(unknownFields, field.name)
 					}
+					continue
+				}
+				switch {
+				case field.quoted && !outfield.quoted:
+					// like json.Unmarshal, the output field gets the string the input field is encoded as
+					val = quote(raw)
+				case outfield.quoted && !field.quoted:
+					// and a string gets decoded for a quoted output field
+					val = dequote(val)
+				}
+				if val.Kind() == reflect.Ptr && val.IsNil() && !v2 {
+					continue
+				}
+				fieldOptions := options
+				if options.Reset {
+					// converting into the same field again merges into what the first time gave
+					fieldOptions.Reset = !containsIndex(written, outfield.index)
+					written = append(written, outfield.index)
+				}
+				err := toStructImpl(val, fieldByIndex(out, outfield.index, true), fieldOptions, rec.next())
+				if err := saveError(&savedErrs, options, addErrorContext(err, outType, outfield.name)); err != nil {
+					return err
 				}
 			}
 		}
+		if options.Reset && out.Kind() == reflect.Struct {
+			zeroFieldsExcept(out, written)
+		}
+		if err := saveError(&savedErrs, options, unknownFieldsError(unknownFields)); err != nil {
+			return err
+		}
+		if out.Kind() == reflect.Map && out.IsNil() {
+			// like json.Unmarshal, an empty object still makes a map
+			out.Set(reflect.MakeMap(outType))
+		}
 
 	case reflect.Map:
 		if out.Kind() != reflect.Map && out.Kind() != reflect.Struct {
 			return nil
 		}
-		var lastErr error
-		for _, key := range in.MapKeys() {
-			var keyStr string
-			switch key.Kind() {
-			case reflect.String:
-				keyStr = key.String()
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				keyStr = strconv.FormatInt(key.Int(), 10)
-			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-				keyStr = strconv.FormatUint(key.Uint(), 10)
-			default:
-				return &skipValError{err: &json.UnsupportedTypeError{Type: key.Type()}}
-			}
+		keys := in.MapKeys()
+		if options.CollectErrors || out.Kind() == reflect.Struct && !caseSensitive {
+			// errors are reported in the order we find them, and when keys like "Id" and "id" only match
+			// a field "ID" case-insensitively the last one wins, so use the order json.Marshal would
+			sortMapKeys(keys, options)
+		}
+		for _, key := range keys {
 			val := in.MapIndex(key)
+			keyStr, err := mapKeyString(key, options)
+			if err != nil {
+				if err := saveError(&savedErrs, options, err); err != nil {
+					return err
+				}
+				continue
+			}
 			if val.Kind() == reflect.Interface && !val.IsNil() {
 				val = val.Elem()
 			}
 			switch out.Kind() {
 			case reflect.Map:
-				outVal := reflect.New(toJsonType(outType.Elem()))
-				err := toStructImpl(val, outVal, options, recursionLevel+1)
+				outVal := newMapValue(out, keyStr, options)
+				err := toStructImpl(val, outVal, options, rec.next())
 				var skipErr *skipValError
 				if errors.As(err, &skipErr) {
-					return err
+					if err := saveError(&savedErrs, options, addErrorContext(err, nil, keyStr)); err != nil {
+						return err
+					}
+					continue
 				}
 				if out.IsNil() {
 					outMap := reflect.MakeMap(outType)
 					out.Set(outMap)
 				}
-				outKeyType := outType.Key()
-				var outKey reflect.Value
-				switch outKeyType.Kind() {
-				case reflect.String:
-					outKey = reflect.New(outKeyType).Elem()
-					outKey.SetString(keyStr)
-				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-					n, err := strconv.ParseInt(keyStr, 10, 64)
-					if err != nil || outKeyType.OverflowInt(n) {
-						lastErr = &json.UnmarshalTypeError{Value: "number " + keyStr, Type: outKeyType}
-						continue
+				outKey, keyErr := mapKey(keyStr, outType.Key(), options)
+				if keyErr != nil {
+					if keyErr = saveError(&savedErrs, options, keyErr); keyErr != nil {
+						return keyErr
 					}
-					outKey = reflect.New(outKeyType).Elem()
-					outKey.SetInt(n)
-				case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-					n, err := strconv.ParseUint(keyStr, 10, 64)
-					if err != nil || outKeyType.OverflowUint(n) {
-						lastErr = &json.UnmarshalTypeError{Value: "number " + keyStr, Type: outKeyType}
-						continue
-					}
-					outKey = reflect.New(outKeyType).Elem()
-					outKey.SetUint(n)
-				default:
-					lastErr = &json.UnmarshalTypeError{Value: "number " + keyStr, Type: outKeyType}
 					continue
 				}
-				if outKey.IsValid() {
-					out.SetMapIndex(outKey, outVal.Elem().Convert(outType.Elem()))
+				out.SetMapIndex(outKey, outVal.Elem())
+				if err := saveError(&savedErrs, options, addErrorContext(err, nil, keyStr)); err != nil {
+					return err
 				}
 			case reflect.Struct:
-				keyStr = strings.ToLower(keyStr)
-				if len(outFields) == 0 {
-					outFields = cachedTypeFields(outType)
+				if outFields.list == nil {
+					outFields = cachedTypeFields(outType, options)
 				}
-				for _, field := range outFields {
-					if field.namelower == keyStr {
-						if field.quoted {
-							val = dequote(val)
-						}
-						if val.Kind() == reflect.Ptr && val.IsNil() {
-							continue
-						}
-						err := toStructImpl(val, fieldByIndex(out, field.index, true), options, recursionLevel+1)
-						if err != nil {
-							return err
-						}
+				field := outFields.lookup(keyStr, caseSensitive)
+				if field == nil {
+					if options.DisallowUnknownFields {
+						unknownFields = append(unknownFields, keyStr)
 					}
+					continue
+				}
+				if field.name != keyStr && hasMapKey(in, field.name) {
+					// another key matches the field exactly, and that one wins
+					continue
+				}
+				if field.quoted {
+					val = dequote(val)
+				}
+				if val.Kind() == reflect.Ptr && val.IsNil() && !v2 {
+					continue
+				}
+				fieldOptions := options
+				if options.Reset {
+					// converting into the same field again merges into what the first time gave
+					fieldOptions.Reset = !containsIndex(written, field.index)
+					written = append(written, field.index)
+				}
+				err := toStructImpl(val, fieldByIndex(out, field.index, true), fieldOptions, rec.next())
+				if err := saveError(&savedErrs, options, addErrorContext(err, outType, field.name)); err != nil {
+					return err
 				}
 			}
 		}
-		return lastErr
-	case reflect.Slice:
-		if out.Kind() != reflect.Slice {
-			return nil
+		if options.Reset && out.Kind() == reflect.Struct {
+			zeroFieldsExcept(out, written)
+		}
+		if err := saveError(&savedErrs, options, unknownFieldsError(unknownFields)); err != nil {
+			return err
 		}
-		if out.IsNil() || out.Len() != in.Len() {
-			outSlice := reflect.MakeSlice(outType, in.Len(), in.Cap())
-			out.Set(outSlice)
+		if out.Kind() == reflect.Map && out.IsNil() {
+			// like json.Unmarshal, an empty object still makes a map
+			out.Set(reflect.MakeMap(outType))
 		}
-		for i := 0; i < in.Len(); i++ {
+	case reflect.Slice, reflect.Array:
+		if isBinary(inType, options) {
+			switch {
+			case out.Kind() == reflect.String:
+				out.SetString(base64.StdEncoding.EncodeToString(binaryBytes(in)))
+				return nil
+			case out.Kind() == reflect.Array && !(v2 && isBinary(outType, options) && out.Len() == in.Len()),
+				out.Kind() == reflect.Slice && outType.Elem().Kind() != reflect.Uint8:
+				// this would be a base64 string in JSON, which can't be unmarshaled into an array,
+				// except by v2 when it holds the right number of bytes
+				return &json.UnmarshalTypeError{Value: "string", Type: outType}
+			}
+		} else if v2 && out.Kind() == reflect.Array && (isBinary(outType, options) || out.Len() != in.Len()) {
+			// v2 only unmarshals arrays of the same length, and byte arrays from strings
+			return &json.UnmarshalTypeError{Value: "array", Type: outType}
+		}
+		switch out.Kind() {
+		case reflect.Slice:
+			if options.Reset && !out.IsNil() && out.Cap() >= in.Len() {
+				// reuse the backing array, leaving the elements past in's length alone, since they might not be out's to clear
+				out.SetLen(in.Len())
+			} else if out.IsNil() || out.Len() != in.Len() {
+				outSlice := reflect.MakeSlice(outType, in.Len(), in.Cap())
+				out.Set(outSlice)
+			}
+		case reflect.Array:
+			// like encoding/json, extra input elements are dropped and missing ones are zeroed
+			for i := in.Len(); i < out.Len(); i++ {
+				out.Index(i).Set(reflect.Zero(outType.Elem()))
+			}
+		default:
+			return nil
+		}
+		for i := 0; i < in.Len() && i < out.Len(); i++ {
 			val := in.Index(i)
-			err := toStructImpl(val, out.Index(i), options, recursionLevel+1)
-			if err != nil {
+			err := toStructImpl(val, out.Index(i), options, rec.next())
+			if err := saveError(&savedErrs, options, addErrorContext(err, nil, strconv.Itoa(i))); err != nil {
 				return err
 			}
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint,
 		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Int64, reflect.Uintptr, reflect.Float32,
 		reflect.Bool, reflect.String, reflect.Float64, reflect.Complex64, reflect.Complex128:
-		tryToConvert(in, inType, out, outType, options)
-	case reflect.Array:
-		panic("Array not supported yet!")
+		return tryToConvert(in, inType, out, outType, options)
 	case reflect.Chan, reflect.Func:
 		// do nothing
 	case reflect.Interface:
-		return toStructImpl(in.Elem(), out, options, recursionLevel+1)
+		return toStructImpl(in.Elem(), out, options, rec.next())
 	case reflect.Ptr:
-		return toStructImpl(in.Elem(), out, options, recursionLevel+1)
-	case reflect.UnsafePointer:
-		panic("UnsafePointer not supported!")
+		return toStructImpl(in.Elem(), out, options, rec.next())
 	default:
-		panic(fmt.Sprintf("Unknown kind %v", in.Kind()))
+		// this includes UnsafePointer, json.Marshal would fail on these
+		return &skipValError{err: &PathError{Err: &json.UnsupportedTypeError{Type: inType}}}
 	}
-	return nil
+	return joinErrors(savedErrs)
+}
+
+// resetNull makes out what converting null into a zero value gives for Options.Reset, since null leaves
+// out as it is: a zero value, apart from unexported fields, which are left alone.
+func resetNull(out reflect.Value) {
+	if out.Kind() == reflect.Struct {
+		zeroFieldsExcept(out, nil)
+		return
+	}
+	out.Set(reflect.Zero(out.Type()))
+}
+
+// resetOut gets out ready to convert in into for Options.Reset, so that the result is exactly what
+// converting into a zero value would give. Maps and slices that in will fill are kept to reuse their
+// storage, and so are pointers, since what they point to gets reset in turn. Structs that in will fill
+// are kept too, and toStructImpl zeroes the fields it doesn't convert into afterwards.
+func resetOut(in, out reflect.Value, p *plan) {
+	if !out.CanSet() {
+		return
+	}
+	custom := p.customJson || p.goloose
+	for (in.Kind() == reflect.Ptr || in.Kind() == reflect.Interface) && !in.IsNil() {
+		in = in.Elem()
+	}
+	object := in.Kind() == reflect.Map || in.Kind() == reflect.Struct
+	array := in.Kind() == reflect.Slice || in.Kind() == reflect.Array
+	switch out.Kind() {
+	case reflect.Ptr:
+		if custom {
+			out.Set(reflect.Zero(out.Type()))
+		} else if p.fastPath && !out.IsNil() {
+			// fastPathMapStringAny converts into what this points to directly
+			resetOut(in, out.Elem(), &plan{})
+		}
+	case reflect.Map:
+		if custom || !object {
+			out.Set(reflect.Zero(out.Type()))
+		} else if !out.IsNil() {
+			out.Clear()
+		}
+	case reflect.Struct:
+		if custom || !object {
+			out.Set(reflect.Zero(out.Type()))
+		}
+	case reflect.Slice:
+		if custom || !array {
+			out.Set(reflect.Zero(out.Type()))
+		}
+	default:
+		out.Set(reflect.Zero(out.Type()))
+	}
+}
+
+// newMapValue returns a pointer to a new value to convert into for the keyStr entry of the map out.
+// Like json.Unmarshal it starts out zero, but v2 starts from the existing entry.
+func newMapValue(out reflect.Value, keyStr string, options Options) reflect.Value {
+	outVal := reflect.New(out.Type().Elem())
+	if options.Semantics == SemanticsV2 && !out.IsNil() {
+		if key, err := mapKey(keyStr, out.Type().Key(), options); err == nil {
+			if existing := out.MapIndex(key); existing.IsValid() {
+				outVal.Elem().Set(existing)
+			}
+		}
+	}
+	return outVal
+}
+
+// mapKey converts a JSON object key into a key for a map of type keyType.
+func mapKey(keyStr string, keyType reflect.Type, options Options) (reflect.Value, error) {
+	key := reflect.New(keyType).Elem()
+	if reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+		// like json.Unmarshal, this comes before the key's kind, even for strings
+		return key, unmarshalText([]byte(keyStr), key)
+	}
+	switch keyType.Kind() {
+	case reflect.String:
+		key.SetString(keyStr)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(keyStr, 10, 64)
+		if err != nil || keyType.OverflowInt(n) {
+			return key, &json.UnmarshalTypeError{Value: "number " + keyStr, Type: keyType}
+		}
+		key.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(keyStr, 10, 64)
+		if err != nil || keyType.OverflowUint(n) {
+			return key, &json.UnmarshalTypeError{Value: "number " + keyStr, Type: keyType}
+		}
+		key.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		// v2 allows float keys, v1 doesn't
+		n, err := strconv.ParseFloat(keyStr, keyType.Bits())
+		if options.Semantics != SemanticsV2 || err != nil {
+			return key, &json.UnmarshalTypeError{Value: "number " + keyStr, Type: keyType}
+		}
+		key.SetFloat(n)
+	case reflect.Interface:
+		if !interfaceMapKeys || keyType.NumMethod() != 0 {
+			return key, &json.UnmarshalTypeError{Value: "number " + keyStr, Type: keyType}
+		}
+		key.Set(reflect.ValueOf(keyStr))
+	default:
+		return key, &json.UnmarshalTypeError{Value: "number " + keyStr, Type: keyType}
+	}
+	return key, nil
+}
+
+// A PathError records an error and the location of the value that caused it.
+type PathError struct {
+	// Path is a dot-separated list of the struct fields, map keys and slice indexes
+	// leading to the value, or empty if the error came from the top-level value.
+	Path string
+	Err  error
+}
+
+func (e *PathError) Unwrap() error { return e.Err }
+func (e *PathError) Error() string {
+	if e.Path == "" {
+		return "goloose: " + e.Err.Error()
+	}
+	return "goloose: " + e.Path + ": " + e.Err.Error()
+}
+
+// ErrUnknownField is reported when Options.DisallowUnknownFields is set and an input
+// field doesn't match any field in the output struct.
+var ErrUnknownField = errors.New("unknown field")
+
+// unknownFieldsError returns an error for each of the unmatched names, or nil if there aren't any.
+func unknownFieldsError(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	errs := make([]error, len(names))
+	for i, name := range names {
+		errs[i] = &PathError{Path: name, Err: ErrUnknownField}
+	}
+	return joinErrors(errs)
+}
+
+// saveError records err in saved if it's an UnmarshalTypeError, which json.Unmarshal only
+// reports after converting everything else it can. Any other error is returned as-is.
+// With Options.CollectErrors every error is recorded, and nothing is returned.
+func saveError(saved *[]error, options Options, err error) error {
+	if err == nil {
+		return nil
+	}
+	if options.CollectErrors {
+		// the caller has already skipped the value, nobody further up needs to know
+		for skipErr, ok := err.(*skipValError); ok; skipErr, ok = err.(*skipValError) {
+			err = skipErr.err
+		}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			*saved = append(*saved, joined.Unwrap()...)
+		} else {
+			*saved = append(*saved, err)
+		}
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	var skipErr *skipValError
+	if errors.As(err, &typeErr) && !errors.As(err, &skipErr) {
+		if len(*saved) == 0 {
+			*saved = append(*saved, err)
+		}
+		return nil
+	}
+	return err
+}
+
+// joinErrors is like errors.Join, but doesn't wrap a single error.
+func joinErrors(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// mapKeyString returns the JSON object key json.Marshal would use for key.
+func mapKeyString(key reflect.Value, options Options) (string, error) {
+	if interfaceMapKeys && key.Kind() == reflect.Interface && !key.IsNil() {
+		key = key.Elem()
+	}
+	if (key.Kind() != reflect.String || textMarshalerStringKeys || options.Semantics == SemanticsV2) && key.Type().Implements(textMarshalerType) {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+		b, err := marshalText(key)
+		return string(b), err
+	}
+	switch key.Kind() {
+	case reflect.String:
+		if options.Semantics == SemanticsV2 && !utf8.ValidString(key.String()) {
+			break
+		}
+		return key.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		if f := key.Float(); options.Semantics == SemanticsV2 && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return formatFloat(f, key.Type().Bits()), nil
+		}
+	}
+	return "", &skipValError{err: &PathError{Err: &json.UnsupportedTypeError{Type: key.Type()}}}
+}
+
+// sortMapKeys sorts keys by their JSON object keys, the same order json.Marshal uses.
+func sortMapKeys(keys []reflect.Value, options Options) {
+	type keyWithString struct {
+		key reflect.Value
+		str string
+	}
+	sorted := make([]keyWithString, len(keys))
+	for i, key := range keys {
+		str, _ := mapKeyString(key, options)
+		sorted[i] = keyWithString{key, str}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].str < sorted[j].str })
+	for i := range sorted {
+		keys[i] = sorted[i].key
+	}
+}
+
+// hasMapKey reports whether the map m has a key that's exactly the string name.
+func hasMapKey(m reflect.Value, name string) bool {
+	switch keyType := m.Type().Key(); keyType.Kind() {
+	case reflect.String:
+		return m.MapIndex(reflect.ValueOf(name).Convert(keyType)).IsValid()
+	case reflect.Interface:
+		return keyType.NumMethod() == 0 && m.MapIndex(reflect.ValueOf(name)).IsValid()
+	}
+	return false
+}
+
+// addErrorContext records that err happened at name, within structType if that's non-nil.
+// Like json.Unmarshal, UnmarshalTypeErrors get their Struct and Field filled in,
+// and any other error is reported as a PathError.
+func addErrorContext(err error, structType reflect.Type, name string) error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		// from Options.CollectErrors, each error needs its own context
+		errs := joined.Unwrap()
+		withContext := make([]error, len(errs))
+		for i, e := range errs {
+			withContext[i] = addErrorContext(e, structType, name)
+		}
+		return errors.Join(withContext...)
+	}
+	if skipErr, ok := err.(*skipValError); ok {
+		// keep this outermost, ToStruct needs to unwrap it
+		skipErr.err = addErrorContext(skipErr.err, structType, name)
+		return skipErr
+	}
+	if cycleErr, ok := err.(*cycleError); ok {
+		if !cycleErr.looping || !rotateCyclePath(cycleErr.path, name) {
+			cycleErr.looping = false
+			cycleErr.path.Path = joinPath(name, cycleErr.path.Path)
+		}
+		return cycleErr
+	}
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		if structType != nil {
+			// the error unwinds from the innermost struct out, and like json.Unmarshal the outermost one names it
+			typeErr.Struct = structType.Name()
+		}
+		typeErr.Field = joinPath(name, typeErr.Field)
+		return typeErr
+	}
+	var pathErr *PathError
+	if errors.As(err, &pathErr) {
+		pathErr.Path = joinPath(name, pathErr.Path)
+		return err
+	}
+	return &PathError{Path: name, Err: err}
+}
+
+func joinPath(name, path string) string {
+	if path == "" {
+		return name
+	}
+	return name + "." + path
 }
 
 type skipValError struct{ err error }
@@ -389,33 +989,48 @@ type skipValError struct{ err error }
 func (e *skipValError) Unwrap() error { return e.err }
 func (e *skipValError) Error() string { return e.err.Error() }
 
-var trueVal = reflect.ValueOf(true)
-var falseVal = reflect.ValueOf(false)
+// A cycleError is a PathError for a cycle in the input. Cycles are only found after startDetectingCyclesAfter
+// levels, so it keeps its path from going around the cycle more than once instead of growing with every level.
+type cycleError struct {
+	path *PathError
+	key  cycleKey // of the value that was seen twice
 
-func tryToConvert(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type, options Options) {
-	if inType == outType {
-		out.Set(in)
-		return
-	}
-	switch in.Kind() {
-	case reflect.String:
-		if out.Kind() == reflect.Bool {
-			switch strings.ToLower(in.String()) {
-			case "true":
-				out.Set(trueVal)
-			case "false":
-				out.Set(falseVal)
-			}
+	// whether path goes around the cycle from where the error has unwound to, and back
+	looping bool
+}
+
+func (e *cycleError) Unwrap() error { return e.path }
+func (e *cycleError) Error() string { return e.path.Error() }
+
+// startCycleLoops marks the errors in err for cycles through key, which toStructImpl is returning from,
+// since their paths have just gone around the cycle once.
+func startCycleLoops(err error, key cycleKey) {
+	switch err := err.(type) {
+	case *skipValError:
+		startCycleLoops(err.err, key)
+	case *cycleError:
+		if err.key == key {
+			err.looping = true
 		}
-		if options.StringToFloat64 && out.Kind() == reflect.Float64 {
-			if f, err := strconv.ParseFloat(in.String(), 64); err == nil {
-				out.Set(reflect.ValueOf(f).Convert(outType))
-			}
+	case interface{ Unwrap() []error }:
+		for _, e := range err.Unwrap() {
+			startCycleLoops(e, key)
 		}
 	}
-	if inType.ConvertibleTo(outType) {
-		out.Set(in.Convert(outType))
+}
+
+// rotateCyclePath reports whether pathErr's path, which goes around a cycle, goes back to the value containing
+// name after it, and if so turns it into the path around the cycle from that value instead.
+func rotateCyclePath(pathErr *PathError, name string) bool {
+	if pathErr.Path == name {
+		return true
+	}
+	rest, ok := strings.CutSuffix(pathErr.Path, "."+name)
+	if !ok {
+		return false
 	}
+	pathErr.Path = name + "." + rest
+	return true
 }
 
 // reference version to compare against
@@ -430,10 +1045,36 @@ func toStructSlow(in interface{}, out interface{}) error {
 	return json.Unmarshal(tmp, &out)
 }
 
-func toJsonType(t reflect.Type) reflect.Type {
+// toJsonType returns the type json.Unmarshal would use for a value of type t
+// when decoding it into an interface{}.
+func toJsonType(t reflect.Type, options Options) reflect.Type {
+	if t == numberType {
+		if options.UseNumber {
+			return numberType
+		}
+		return float64Type
+	}
 	switch t.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint,
-		reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Int64, reflect.Uintptr, reflect.Float32:
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if options.UseNumber {
+			return numberType
+		}
+		if options.PreserveIntegers {
+			return int64Type
+		}
+		return float64Type
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if options.UseNumber {
+			return numberType
+		}
+		if options.PreserveIntegers {
+			return uint64Type
+		}
+		return float64Type
+	case reflect.Float32, reflect.Float64:
+		if options.UseNumber {
+			return numberType
+		}
 		return float64Type
 	case reflect.String:
 		return stringType
@@ -443,6 +1084,27 @@ func toJsonType(t reflect.Type) reflect.Type {
 	return t
 }
 
+// jsonValueKind describes the JSON value json.Marshal produces for a t, for use in an UnmarshalTypeError.
+func jsonValueKind(t reflect.Type) string {
+	if t == numberType {
+		return "number"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if isByteSlice(t) {
+			return "string"
+		}
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return "number"
+}
+
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
 	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
@@ -461,6 +1123,25 @@ func isEmptyValue(v reflect.Value) bool {
 	return false
 }
 
+// isByteSlice reports whether t is a slice that encoding/json would encode as a base64 string.
+// Byte arrays are encoded as regular JSON arrays, so they don't count.
+func isByteSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uint8 {
+		return false
+	}
+	elemPtr := reflect.PointerTo(t.Elem())
+	return !elemPtr.Implements(jsonMarshalerType) && !elemPtr.Implements(textMarshalerType)
+}
+
+// isNull reports whether val is encoded as a JSON null.
+func isNull(val reflect.Value, options Options) bool {
+	if options.Semantics == SemanticsV2 && (val.Kind() == reflect.Map || val.Kind() == reflect.Slice) {
+		// v2 encodes these as {} and []
+		return false
+	}
+	return isNil(val)
+}
+
 func isNil(val reflect.Value) bool {
 	switch val.Kind() {
 	case reflect.Invalid:
@@ -472,42 +1153,184 @@ func isNil(val reflect.Value) bool {
 }
 
 var float64Type = reflect.TypeOf(float64(0))
+var int64Type = reflect.TypeOf(int64(0))
+var uint64Type = reflect.TypeOf(uint64(0))
+var numberType = reflect.TypeOf(json.Number(""))
 var stringType = reflect.TypeOf(string(""))
 var mapStringInterfaceType = reflect.TypeOf(map[string]interface{}{})
+var mapStringInterfacePtrType = reflect.TypeOf(new(map[string]interface{}))
+var mapStringStringType = reflect.TypeOf(map[string]string{})
+var mapStringFloat64Type = reflect.TypeOf(map[string]float64{})
+var mapStringIntType = reflect.TypeOf(map[string]int{})
+var interfacePtrType = reflect.TypeOf(new(interface{}))
 var interfaceSliceType = reflect.TypeOf([]interface{}{})
 var timeType = reflect.TypeOf(time.Time{})
-var timePtrType = reflect.TypeOf(&time.Time{})
 var jsonMarshalerType = reflect.TypeOf(new(json.Marshaler)).Elem()
 var jsonUnmarshalerType = reflect.TypeOf(new(json.Unmarshaler)).Elem()
 var textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
 var textUnmarshalerType = reflect.TypeOf(new(encoding.TextUnmarshaler)).Elem()
 
-func customJson(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type) (bool, error) {
+// methodFlags records which of the interfaces goloose looks for a type implements.
+type methodFlags uint8
+
+const (
+	jsonMarshalerMethod methodFlags = 1 << iota
+	jsonUnmarshalerMethod
+	textMarshalerMethod
+	textUnmarshalerMethod
+	golooseValuerMethod
+	golooseValueSetterMethod
+)
+
+var methodFlagsCache sync.Map // map[reflect.Type]methodFlags
+
+// methodsOf returns the methodFlags for t. It's cached because Implements is slow for types with lots of methods.
+func methodsOf(t reflect.Type) methodFlags {
+	if flags, ok := methodFlagsCache.Load(t); ok {
+		return flags.(methodFlags)
+	}
+	var flags methodFlags
+	for flag, iface := range map[methodFlags]reflect.Type{
+		jsonMarshalerMethod:      jsonMarshalerType,
+		jsonUnmarshalerMethod:    jsonUnmarshalerType,
+		textMarshalerMethod:      textMarshalerType,
+		textUnmarshalerMethod:    textUnmarshalerType,
+		golooseValuerMethod:      golooseValuerType,
+		golooseValueSetterMethod: golooseValueSetterType,
+	} {
+		if t.Implements(iface) {
+			flags |= flag
+		}
+	}
+	methodFlagsCache.Store(t, flags)
+	return flags
+}
+
+func customJson(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type, options Options, rec recursion) (bool, error) {
 	if !out.CanAddr() {
 		return false, nil
 	}
+	v2 := options.Semantics == SemanticsV2 && (needsJSONv2(inType, options) || needsJSONv2(outType, options))
 	outType = reflect.PointerTo(outType)
-	inOk := inType.Implements(jsonMarshalerType) || inType.Implements(textMarshalerType)
-	outOk := outType.Implements(jsonUnmarshalerType) || outType.Implements(textUnmarshalerType)
-	if inOk || outOk {
+	inOk := methodsOf(inType)&(jsonMarshalerMethod|textMarshalerMethod) != 0
+	outOk := methodsOf(outType)&(jsonUnmarshalerMethod|textUnmarshalerMethod) != 0
+	if inOk || outOk || v2 {
 		if timeFastPath(in, inType, out, outType) {
 			return true, nil
 		}
+		if !v2 {
+			if handled, err := textFastPath(in, out, outType, options, rec); handled {
+				return true, err
+			}
+		}
 
-		b, err := json.Marshal(in.Interface())
+		b, err := marshalJSON(in, options)
 		if err != nil {
 			return true, &skipValError{err: err}
 		}
-		outInter := out.Addr().Interface()
-		err = json.Unmarshal(b, &outInter)
-		if err != nil {
-			return true, err
-		}
-		return true, err
+		return true, unmarshalJSON(b, out, options)
 	}
 	return false, nil
 }
 
+// marshalJSON calls json.Marshal, or the encoding/json/v2 version for SemanticsV2,
+// turning a panic in a user-defined marshaler into an error.
+func marshalJSON(in reflect.Value, options Options) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PathError{Err: fmt.Errorf("panic marshaling %v: %v", in.Type(), r)}
+		}
+	}()
+	if options.Semantics == SemanticsV2 {
+		return marshalV2(in.Interface())
+	}
+	return json.Marshal(in.Interface())
+}
+
+// unmarshalJSON calls json.Unmarshal into the addressable value out, or the encoding/json/v2
+// version for SemanticsV2, turning a panic in a user-defined unmarshaler into an error.
+func unmarshalJSON(b []byte, out reflect.Value, options Options) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PathError{Err: fmt.Errorf("panic unmarshaling into %v: %v", out.Type(), r)}
+		}
+	}()
+	outInter := out.Addr().Interface()
+	if options.Semantics == SemanticsV2 {
+		return unmarshalV2(b, outInter)
+	}
+	if options.UseNumber {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		return dec.Decode(&outInter)
+	}
+	return json.Unmarshal(b, &outInter)
+}
+
+// textFastPath calls MarshalText and UnmarshalText directly when those are the only methods
+// json.Marshal and json.Unmarshal would call, instead of quoting the text and unquoting it again.
+// outPtrType is the type of a pointer to out.
+func textFastPath(in reflect.Value, out reflect.Value, outPtrType reflect.Type, options Options, rec recursion) (bool, error) {
+	outMethods := methodsOf(outPtrType)
+	if outMethods&jsonUnmarshalerMethod != 0 {
+		return false, nil
+	}
+	var inMethods methodFlags
+	for {
+		if isNil(in) {
+			return false, nil
+		}
+		inMethods = methodsOf(in.Type())
+		if inMethods&jsonMarshalerMethod != 0 {
+			return false, nil
+		}
+		if inMethods&textMarshalerMethod != 0 || in.Kind() != reflect.Interface && in.Kind() != reflect.Ptr {
+			break
+		}
+		in = in.Elem()
+	}
+	outOk := outMethods&textUnmarshalerMethod != 0
+	var text []byte
+	switch {
+	case inMethods&textMarshalerMethod != 0:
+		b, err := marshalText(in)
+		if err != nil || !utf8.Valid(b) {
+			// let encoding/json report the error, or replace the invalid UTF-8 the way it does
+			return false, nil
+		}
+		text = b
+	case in.Kind() == reflect.String && outOk && utf8.ValidString(in.String()):
+		text = []byte(in.String())
+	default:
+		return false, nil
+	}
+	if outOk {
+		return true, unmarshalText(text, out)
+	}
+	// like the string encoding/json would produce
+	return true, toStructImpl(reflect.ValueOf(string(text)), out, options, rec.next())
+}
+
+// marshalText calls MarshalText on in, turning a panic into an error like marshalJSON does.
+func marshalText(in reflect.Value) (b []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PathError{Err: fmt.Errorf("panic marshaling %v: %v", in.Type(), r)}
+		}
+	}()
+	return in.Interface().(encoding.TextMarshaler).MarshalText()
+}
+
+// unmarshalText calls UnmarshalText on the addressable value out, turning a panic into an error like unmarshalJSON does.
+func unmarshalText(text []byte, out reflect.Value) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PathError{Err: fmt.Errorf("panic unmarshaling into %v: %v", out.Type(), r)}
+		}
+	}()
+	return out.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+}
+
 func timeFastPath(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type) bool {
 	switch inType {
 	case timeType:
@@ -519,18 +1342,6 @@ func timeFastPath(in reflect.Value, inType reflect.Type, out reflect.Value, outT
 			t := in.Interface().(time.Time)
 			out.Set(reflect.ValueOf(t.Format(time.RFC3339Nano)))
 		}
-	case timePtrType:
-		switch outType {
-		case timePtrType:
-			if !in.IsNil() {
-				outVal := reflect.New(timeType)
-				outVal.Elem().Set(in.Elem())
-				out.Set(outVal)
-			} else {
-				out.Set(in)
-			}
-			return true
-		}
 	case stringType:
 		switch outType {
 		case timeType:
@@ -561,6 +1372,11 @@ func dequote(v reflect.Value) reflect.Value {
 	if !strings.HasPrefix(str, `"`) || !strings.HasSuffix(str, `"`) {
 		return v
 	}
+	// like json.Unmarshal, decode the escapes json.Marshal puts in the quoted string
+	var unquoted string
+	if err := json.Unmarshal([]byte(str), &unquoted); err == nil {
+		return reflect.ValueOf(unquoted)
+	}
 	str = str[1 : len(str)-1]
 	return reflect.ValueOf(str)
 }
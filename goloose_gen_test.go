@@ -0,0 +1,193 @@
+// Code generated by goloose-gen. DO NOT EDIT.
+
+package goloose
+
+import (
+	"math"
+)
+
+var genOrderToDTOOptions = Options{}
+
+// genOrderToDTO converts *in into *out like ToStruct(in, out, genOrderToDTOOptions).
+func genOrderToDTO(in *genOrder, out *genOrderDTO) error {
+	if in == nil {
+		return nil
+	}
+	return genOrderToDTOGenOrderToGenOrderDTO(in, out, 0)
+}
+
+// genItemToDTO converts *in into *out like ToStruct(in, out, genOrderToDTOOptions).
+func genItemToDTO(in *genItem, out *genItemDTO) error {
+	if in == nil {
+		return nil
+	}
+	return genOrderToDTOGenItemToGenItemDTO(in, out, 0)
+}
+
+// genIDToString converts *in into *out like ToStruct(in, out, genOrderToDTOOptions).
+func genIDToString(in *textID, out *string) error {
+	return ToStruct(in, out, genOrderToDTOOptions)
+}
+
+func genOrderToDTOGenOrderToGenOrderDTO(in *genOrder, out *genOrderDTO, depth int) error {
+	s, err := StartGenerated(out, genOrderToDTOOptions, depth)
+	if err != nil {
+		return err
+	}
+	if deep, err := s.ConvertDeep(in, out); deep {
+		return err
+	}
+	if in.genBase != nil {
+		out.genBase.ID = in.genBase.ID
+	}
+	if in.genBase != nil {
+		if in.genBase.Created != 0 {
+			if out.Created == nil {
+				out.Created = new(int64)
+			}
+			*out.Created = in.genBase.Created
+		}
+	}
+	out.Customer = in.Customer
+	if out.GenAudit == nil {
+		out.GenAudit = new(GenAudit)
+	}
+	out.GenAudit.By = in.By
+	if math.IsNaN(float64(in.Total)) || math.IsInf(float64(in.Total), 0) {
+		if err := s.UnsupportedFloat(in.Total, "Total"); err != nil {
+			return s.Done(err)
+		}
+	} else {
+		out.Total = in.Total
+	}
+	if in.Paid {
+		out.Paid = in.Paid
+	}
+	out.Count = int64(in.Count)
+	out.Code = in.Code
+	if err := s.QuoteField(&in.Label, &out.Label, "Label"); err != nil {
+		return s.Done(err)
+	}
+	if err := s.QuoteField(&in.Score, &out.Score, "Score"); err != nil {
+		return s.Done(err)
+	}
+	if in.Rate != nil {
+		if out.Rate == nil {
+			out.Rate = new(*float64)
+		}
+		if *out.Rate == nil {
+			*out.Rate = new(float64)
+		}
+		if math.IsNaN(float64(*in.Rate)) || math.IsInf(float64(*in.Rate), 0) {
+			if err := s.UnsupportedFloat(*in.Rate, "Rate"); err != nil {
+				return s.Done(err)
+			}
+		} else {
+			**out.Rate = *in.Rate
+		}
+	}
+	if in.First != nil {
+		if out.First == nil {
+			out.First = new(genItemDTO)
+		}
+		if err := s.Nested(genOrderToDTOGenItemToGenItemDTO(in.First, out.First, depth+1), "First"); err != nil {
+			return s.Done(err)
+		}
+	}
+	if in.Items == nil {
+		out.Items = nil
+	} else {
+		if out.Items == nil || len(out.Items) != len(in.Items) {
+			out.Items = make([]genItemDTO, len(in.Items), cap(in.Items))
+		}
+		for i := range in.Items {
+			if err := s.Index(genOrderToDTOGenItemToGenItemDTO(&in.Items[i], &out.Items[i], depth+2), "Items", i); err != nil {
+				return s.Done(err)
+			}
+		}
+	}
+	if in.Lines == nil {
+		out.Lines = nil
+	} else {
+		for k, v := range in.Lines {
+			var elem genItemDTO
+			set, err := s.Entry(genOrderToDTOGenItemToGenItemDTO(&v, &elem, depth+2), "Lines", k)
+			if set {
+				if out.Lines == nil {
+					out.Lines = make(map[string]genItemDTO, len(in.Lines))
+				}
+				out.Lines[k] = elem
+			}
+			if err != nil {
+				return s.Done(err)
+			}
+		}
+		if out.Lines == nil {
+			// like json.Unmarshal, an empty object still makes a map
+			out.Lines = map[string]genItemDTO{}
+		}
+	}
+	if in.Tags == nil {
+		out.Tags = nil
+	} else {
+		if out.Tags == nil || len(out.Tags) != len(in.Tags) {
+			out.Tags = make([]string, len(in.Tags), cap(in.Tags))
+		}
+		copy(out.Tags, in.Tags)
+	}
+	if in.IDs == nil {
+		out.IDs = nil
+	} else {
+		if out.IDs == nil || len(out.IDs) != len(in.IDs) {
+			out.IDs = make([]int64, len(in.IDs), cap(in.IDs))
+		}
+		for i, v := range in.IDs {
+			out.IDs[i] = int64(v)
+		}
+	}
+	if err := s.Field(&in.Meta, &out.Meta, "Meta"); err != nil {
+		return s.Done(err)
+	}
+	if err := s.Field(&in.Note, &out.Note, "Note"); err != nil {
+		return s.Done(err)
+	}
+	if err := s.Field(&in.When, &out.When, "When"); err != nil {
+		return s.Done(err)
+	}
+	if err := s.Field(&in.Ref, &out.Ref, "Ref"); err != nil {
+		return s.Done(err)
+	}
+	if !s.IsZero(&in.Flags) {
+		if err := s.Nested(genOrderToDTOGenFlagsToGenFlags(&in.Flags, &out.Flags, depth+1), "Flags"); err != nil {
+			return s.Done(err)
+		}
+	}
+	return s.Done(nil)
+}
+
+func genOrderToDTOGenItemToGenItemDTO(in *genItem, out *genItemDTO, depth int) error {
+	s, err := StartGenerated(out, genOrderToDTOOptions, depth)
+	if err != nil {
+		return err
+	}
+	out.Sku = in.SKU
+	out.Qty = int64(in.Qty)
+	if math.IsNaN(float64(in.Price)) || math.IsInf(float64(in.Price), 0) {
+		if err := s.UnsupportedFloat(in.Price, "Price"); err != nil {
+			return s.Done(err)
+		}
+	} else {
+		out.Price = in.Price
+	}
+	return s.Done(nil)
+}
+
+func genOrderToDTOGenFlagsToGenFlags(in *genFlags, out *genFlags, depth int) error {
+	s, err := StartGenerated(out, genOrderToDTOOptions, depth)
+	if err != nil {
+		return err
+	}
+	out.Rush = in.Rush
+	out.Gift = in.Gift
+	return s.Done(nil)
+}
@@ -1,13 +1,23 @@
 package goloose
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"math"
 	"math/big"
+	"net/netip"
+	"os"
 	"reflect"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func toJson(in interface{}) string {
@@ -339,8 +349,13 @@ func TestInvalidTime(t *testing.T) {
 	if !reflect.DeepEqual(a, b) {
 		t.Errorf("Got %v\nExpected: %v", a, b)
 	}
-	if !reflect.DeepEqual(aErr, bErr) {
-		t.Errorf("Got %v\nExpected: %v", aErr, bErr)
+	// goloose also reports where the error happened
+	var pathErr *PathError
+	if !errors.As(aErr, &pathErr) || pathErr.Path != "time" {
+		t.Errorf("Expected a PathError at time, got %v", aErr)
+	}
+	if !reflect.DeepEqual(errors.Unwrap(aErr), bErr) {
+		t.Errorf("Got %v\nExpected: %v", errors.Unwrap(aErr), bErr)
 	}
 }
 
@@ -374,6 +389,102 @@ func TestStringJSONInt64(t *testing.T) {
 	}
 }
 
+func TestStringOptionToMap(t *testing.T) {
+	one := 1.5
+	type Foo struct {
+		Id    int64    `json:"id,string"`
+		Ok    bool     `json:"ok,string"`
+		Name  string   `json:"name,string"`
+		Ratio *float64 `json:"ratio,string"`
+		Nil   *int     `json:"nil,string"`
+	}
+	in := Foo{Id: 131412412412412412, Ok: true, Name: "<a>", Ratio: &one}
+	var a, b map[string]any
+	if err := ToStruct(in, &a); err != nil {
+		t.Fatal(err)
+	}
+	toStructSlow(in, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Got %v\nExpected: %v", a, b)
+	}
+	// json.Marshal quotes these, so the map gets the strings
+	want := map[string]any{"id": "131412412412412412", "ok": "true", "name": `"\u003ca\u003e"`, "ratio": "1.5", "nil": nil}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("Got %#v\nExpected: %#v", a, want)
+	}
+
+	var strs map[string]string
+	if err := ToStruct(Foo{Id: 7, Name: "x"}, &strs); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"id": "7", "ok": "false", "name": `"x"`, "ratio": "", "nil": ""}; !reflect.DeepEqual(strs, want) {
+		t.Errorf("Got %#v\nExpected: %#v", strs, want)
+	}
+
+	// and converting the map back gives the struct again
+	var back Foo
+	if err := ToStruct(a, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, in) {
+		t.Errorf("Got %+v back, expected %+v", back, in)
+	}
+}
+
+func TestStringOptionToStruct(t *testing.T) {
+	type Quoted struct {
+		Id    int64   `json:"id,string"`
+		Ok    bool    `json:"ok,string"`
+		Name  string  `json:"name,string"`
+		Ratio float64 `json:"ratio,string"`
+	}
+	type Plain struct {
+		Id    any    `json:"id"`
+		Ok    string `json:"ok"`
+		Name  string `json:"name"`
+		Ratio string `json:"ratio"`
+	}
+	type Both struct {
+		Id    int64   `json:"id,string"`
+		Ok    bool    `json:"ok,string"`
+		Name  string  `json:"name,string"`
+		Ratio float32 `json:"ratio,string"`
+	}
+	in := Quoted{Id: 7, Ok: true, Name: "x", Ratio: 1.5}
+
+	// the plain fields get the strings json.Marshal quotes the fields as
+	var a, b Plain
+	if err := ToStruct(in, &a); err != nil {
+		t.Fatal(err)
+	}
+	toStructSlow(in, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Got %#v\nExpected: %#v", a, b)
+	}
+	if want := (Plain{Id: "7", Ok: "true", Name: `"x"`, Ratio: "1.5"}); !reflect.DeepEqual(a, want) {
+		t.Errorf("Got %#v\nExpected: %#v", a, want)
+	}
+
+	// quoted fields take the values as they are
+	var c, d Both
+	if err := ToStruct(in, &c); err != nil {
+		t.Fatal(err)
+	}
+	toStructSlow(in, &d)
+	if !reflect.DeepEqual(c, d) {
+		t.Errorf("Got %#v\nExpected: %#v", c, d)
+	}
+
+	// and the strings decode again going into quoted fields
+	var back Quoted
+	if err := ToStruct(a, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, in) {
+		t.Errorf("Got %+v back, expected %+v", back, in)
+	}
+}
+
 func TestEmbeddedFunc(t *testing.T) {
 	type Foo struct {
 		Bar string     `json:"bar"`
@@ -516,6 +627,81 @@ func BenchmarkToStruct(b *testing.B) {
 	}
 }
 
+func BenchmarkConverter(b *testing.B) {
+	type Foo struct {
+		A string `json:"a"`
+		B string `json:"b"`
+		C string `json:"c"`
+	}
+	type Foo2 struct {
+		A string `json:"a"`
+		B string `json:"b"`
+	}
+	c, err := NewConverter[Foo, Foo2](Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	f := Foo{A: "some a", B: "some b", C: "some C"}
+	for i := 0; i < b.N; i++ {
+		var foo2 Foo2
+		_ = c.ConvertInto(f, &foo2)
+		_ = foo2
+	}
+}
+
+func BenchmarkToStructManyFields(b *testing.B) {
+	type Foo struct {
+		A, B, C, D, E, F, G, H, I, J string
+		K, L, M, N, O, P, Q, R, S, T int
+	}
+	type Foo2 struct {
+		A, C, E, G, I string
+		K, M, O, Q, S float64
+		Extra         bool
+	}
+	f := Foo{A: "a", C: "c", E: "e", G: "g", I: "i", K: 1, M: 2, O: 3, Q: 4, S: 5}
+	for i := 0; i < b.N; i++ {
+		var foo2 Foo2
+		if err := ToStruct(f, &foo2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type textHosts struct {
+	Addr netip.Addr `json:"addr"`
+	ID   textID     `json:"id"`
+}
+
+type stringHosts struct {
+	Addr string `json:"addr"`
+	ID   string `json:"id"`
+}
+
+func BenchmarkTextMarshalerSlow(b *testing.B) {
+	in := textHosts{Addr: netip.MustParseAddr("192.168.0.1"), ID: "abc"}
+	for i := 0; i < b.N; i++ {
+		var out stringHosts
+		var back textHosts
+		_ = toStructSlow(in, &out)
+		_ = toStructSlow(out, &back)
+	}
+}
+
+func BenchmarkTextMarshaler(b *testing.B) {
+	in := textHosts{Addr: netip.MustParseAddr("192.168.0.1"), ID: "abc"}
+	for i := 0; i < b.N; i++ {
+		var out stringHosts
+		var back textHosts
+		if err := ToStruct(in, &out); err != nil {
+			b.Fatal(err)
+		}
+		if err := ToStruct(out, &back); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkStringMapToMapAny(b *testing.B) {
 	in := map[string]string{}
 	for x := 0; x < 1000; x++ {
@@ -722,9 +908,11 @@ func TestStringToFloat64(t *testing.T) {
 		StringVal: "42",
 	}
 
+	// like json.Unmarshal, the rest of the struct is still filled in
+	var typeErr *json.UnmarshalTypeError
 	err := ToStruct(msg, &x)
-	if err != nil {
-		t.Error(err)
+	if !errors.As(err, &typeErr) {
+		t.Errorf("expected an UnmarshalTypeError, got %v", err)
 	}
 	if !reflect.DeepEqual(x, expected) {
 		t.Errorf("\ndefault options shouldn't convert strings to floats\nexpected: %v\nreceived: %v\n", expected, x)
@@ -741,8 +929,8 @@ func TestStringToFloat64(t *testing.T) {
 	}
 
 	err = ToStruct(msg, &y, Options{StringToFloat64: false})
-	if err != nil {
-		t.Error(err)
+	if !errors.As(err, &typeErr) {
+		t.Errorf("expected an UnmarshalTypeError, got %v", err)
 	}
 	if !reflect.DeepEqual(y, expected) {
 		t.Errorf("\nstringToFloat64 false shouldn't convert strings to floats\nexpected: %v\nreceived: %v\n", expected, x)
@@ -887,6 +1075,86 @@ func TestRecursiveDataStructureDoesntPanic(t *testing.T) {
 	}
 }
 
+type cycleNode struct {
+	Name string
+	Next *cycleNode
+	Kids []any
+}
+
+func TestCycleDetection(t *testing.T) {
+	looped := &cycleNode{Name: "a"}
+	looped.Next = &cycleNode{Name: "b", Next: looped}
+	selfMap := map[string]any{}
+	selfMap["self"] = selfMap
+	selfSlice := []any{nil}
+	selfSlice[0] = selfSlice
+	// goloose only finds cycles in the parts of the input it converts, unlike json.Marshal
+	for _, tc := range []struct {
+		name string
+		in   any
+		via  string
+		path string // around the cycle once, from where it's entered
+		outs []any
+	}{
+		{"pointers", looped, "*goloose.cycleNode", "Next.Next", []any{new(any), new(map[string]any), new(cycleNode)}},
+		{"map", selfMap, "map[string]interface {}", "self", []any{new(any), new(map[string]any)}},
+		{"slice", selfSlice, "[]interface {}", "0", []any{new(any), new([]any)}},
+		{"slice in struct", &cycleNode{Kids: selfSlice}, "[]interface {}", "Kids.0", []any{new(any), new(cycleNode)}},
+		{"pointers in map", map[string]any{"x": []any{1, looped}}, "*goloose.cycleNode", "x.1.Next.Next", []any{new(any)}},
+	} {
+		for _, out := range tc.outs {
+			for _, options := range []Options{{}, {CollectErrors: true}} {
+				err := ToStruct(tc.in, out, options)
+				var valueErr *json.UnsupportedValueError
+				if !errors.As(err, &valueErr) || valueErr.Str != "encountered a cycle via "+tc.via {
+					t.Errorf("%s into %T: got %v, expected a cycle via %s", tc.name, out, err, tc.via)
+				}
+				var pathErr *PathError
+				if !errors.As(err, &pathErr) || pathErr.Path != tc.path {
+					t.Errorf("%s into %T with %+v: got %v, expected the path %s", tc.name, out, options, err, tc.path)
+				}
+			}
+			if _, jsonErr := json.Marshal(tc.in); jsonErr == nil {
+				t.Errorf("%s: json.Marshal didn't find a cycle", tc.name)
+			}
+		}
+	}
+}
+
+func TestDeepDataWithoutCycles(t *testing.T) {
+	// longer than it takes to start looking for cycles, with the same node reachable twice
+	shared := &cycleNode{Name: "shared"}
+	list := &cycleNode{Name: "end", Kids: []any{shared, shared}}
+	for i := 0; i < 2000; i++ {
+		list = &cycleNode{Name: strconv.Itoa(i), Next: list}
+	}
+	var got, want cycleNode
+	if err := ToStruct(list, &got); err != nil {
+		t.Fatal(err)
+	}
+	toStructSlow(list, &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Error("deep list didn't convert like json")
+	}
+
+	err := ToStruct(list, &got, Options{MaxDepth: 100})
+	if !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("got %v, expected ErrMaxDepth", err)
+	}
+	for i := 0; i < 3000; i++ {
+		list = &cycleNode{Name: strconv.Itoa(i), Next: list}
+	}
+	if err := ToStruct(list, &got); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("got %v, expected ErrMaxDepth", err)
+	}
+	if err := ToStruct(list, &got, Options{MaxDepth: 100000}); err != nil {
+		t.Error(err)
+	}
+	if err := ToStruct(list, &got, Options{MaxDepth: -1}); err == nil {
+		t.Error("expected an error for a negative MaxDepth")
+	}
+}
+
 func TestHugeNumber(t *testing.T) {
 	in := struct{ HugeNumber *big.Int }{}
 	in.HugeNumber = big.NewInt(1)
@@ -1082,3 +1350,2713 @@ func TestMapAnyAnyToMapAnyAny(t *testing.T) {
 		t.Errorf("Got %+v\nExpected %+v", b, c)
 	}
 }
+
+func TestArrayToArray(t *testing.T) {
+	type Foo struct {
+		A [3]int     `json:"a"`
+		B [2]float64 `json:"b"`
+		C [16]byte   `json:"c"`
+	}
+	type Bar struct {
+		A [2]int     `json:"a"`
+		B [4]float64 `json:"b"`
+		C [16]byte   `json:"c"`
+	}
+	a := Foo{A: [3]int{1, 2, 3}, B: [2]float64{1.5, 2.5}, C: [16]byte{1, 2, 3, 255}}
+	b := Bar{B: [4]float64{9, 9, 9, 9}}
+	c := Bar{B: [4]float64{9, 9, 9, 9}}
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+}
+
+func TestArrayToSliceAndBack(t *testing.T) {
+	a := [3]int{1, 2, 3}
+	var b, c []float64
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+	var d, e [2]int
+	err = ToStruct(b, &d)
+	err2 = toStructSlow(b, &e)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(d, e) {
+		t.Errorf("Got %+v\nExpected %+v", d, e)
+	}
+}
+
+func TestArrayToInterface(t *testing.T) {
+	a := map[string]any{"vec": [3]float64{1, 2, 3}, "uuid": [4]byte{0xde, 0xad, 0xbe, 0xef}}
+	var b, c map[string]any
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+}
+
+func TestByteSliceToByteArray(t *testing.T) {
+	a := map[string]any{"a": []byte{1, 2, 3}, "b": []byte("hello")}
+	type Foo struct {
+		A [3]byte `json:"a"`
+		B string  `json:"b"`
+	}
+	var b, c Foo
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	var d, e map[string]any
+	err = ToStruct(a, &d)
+	err2 = toStructSlow(a, &e)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(d, e) {
+		t.Errorf("Got %+v\nExpected %+v", d, e)
+	}
+}
+
+func TestUnsafePointerReturnsError(t *testing.T) {
+	x := 1
+	type Foo struct {
+		Ptrs []unsafe.Pointer `json:"ptrs"`
+	}
+	a := map[string]any{"foo": Foo{Ptrs: []unsafe.Pointer{unsafe.Pointer(&x)}}}
+	var b map[string]any
+	err := ToStruct(a, &b)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("Expected a PathError, got %v", err)
+	}
+	if pathErr.Path != "foo.ptrs.0" {
+		t.Errorf("Got path %q, expected %q", pathErr.Path, "foo.ptrs.0")
+	}
+	var typeErr *json.UnsupportedTypeError
+	if !errors.As(err, &typeErr) || typeErr.Type != reflect.TypeOf(unsafe.Pointer(nil)) {
+		t.Errorf("Expected an UnsupportedTypeError for unsafe.Pointer, got %v", err)
+	}
+}
+
+type panicMarshaler struct{}
+
+func (panicMarshaler) MarshalJSON() ([]byte, error) { panic("marshal boom") }
+
+type panicUnmarshaler struct{}
+
+func (*panicUnmarshaler) UnmarshalText([]byte) error { panic("unmarshal boom") }
+
+func TestCustomMarshalerPanicsReturnErrors(t *testing.T) {
+	type Foo struct {
+		Bar struct {
+			Baz panicMarshaler `json:"baz"`
+		} `json:"bar"`
+	}
+	var a Foo
+	var b map[string]any
+	err := ToStruct(a, &b)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "bar.baz" {
+		t.Errorf("Expected a PathError at bar.baz, got %v", err)
+	}
+
+	type Qux struct {
+		Items []panicUnmarshaler `json:"items"`
+	}
+	var c Qux
+	err = ToStruct(map[string]any{"items": []string{"a"}}, &c)
+	if !errors.As(err, &pathErr) || pathErr.Path != "items.0" {
+		t.Errorf("Expected a PathError at items.0, got %v", err)
+	}
+}
+
+func TestTimePtrToTime(t *testing.T) {
+	type Foo struct {
+		T *time.Time `json:"t"`
+	}
+	type Bar struct {
+		T time.Time `json:"t"`
+	}
+	a := Foo{T: timePtr(time.Now().UTC())}
+	var b, c Bar
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+}
+
+func TestStructToMapIntAny(t *testing.T) {
+	a := struct{ A int }{1}
+	var b, c map[int]any
+	err := ToStruct(a, &b)
+	err2 := toStructSlow(a, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+}
+
+func TestScalarConversionsMatchJSON(t *testing.T) {
+	type MyBool bool
+	testCases := []struct {
+		in  any
+		out any
+	}{
+		{65, ""},
+		{300, int8(0)},
+		{-1, uint(0)},
+		{1.5, 0},
+		{2.0, 0},
+		{float64(1 << 60), int64(0)},
+		{1e21, uint64(0)},
+		{uint64(math.MaxUint64), int64(0)},
+		{uint64(math.MaxUint64), float64(0)},
+		{float32(0.1), float64(0)},
+		{1e300, int32(0)},
+		{true, ""},
+		{true, 0},
+		{true, MyBool(false)},
+		{"abc", 0},
+		{"abc", 1.5},
+		{"aGVsbG8=", []byte(nil)},
+		{"not base64", []byte(nil)},
+		{"abc", struct{}{}},
+		{1, []int(nil)},
+		{complex(1, 2), complex(0, 0)},
+		{math.NaN(), 0.0},
+		{math.Inf(1), 0},
+	}
+	for _, tc := range testCases {
+		out := reflect.New(reflect.TypeOf(tc.out))
+		outSlow := reflect.New(reflect.TypeOf(tc.out))
+		err := ToStruct(tc.in, out.Interface())
+		err2 := toStructSlow(tc.in, outSlow.Interface())
+		if (err != nil) != (err2 != nil) {
+			t.Errorf("%T(%v) -> %T: got error %v, expected %v", tc.in, tc.in, tc.out, err, err2)
+		}
+		if !reflect.DeepEqual(out.Elem().Interface(), outSlow.Elem().Interface()) {
+			t.Errorf("%T(%v) -> %T: got %v, expected %v", tc.in, tc.in, tc.out, out.Elem(), outSlow.Elem())
+		}
+	}
+}
+
+func TestScalarErrorsDontStopConversion(t *testing.T) {
+	type Foo struct {
+		A int8   `json:"a"`
+		B string `json:"b"`
+		C []int  `json:"c"`
+	}
+	in := map[string]any{"a": 300, "b": 1, "c": []any{1, "x", 3}}
+	var out, outSlow Foo
+	err := ToStruct(in, &out)
+	err2 := toStructSlow(in, &outSlow)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || !errors.As(err2, &typeErr) {
+		t.Errorf("Got %v\nExpected: %v", err, err2)
+	}
+	if !reflect.DeepEqual(out, outSlow) {
+		t.Errorf("Got %+v\nExpected %+v", out, outSlow)
+	}
+}
+
+func TestUnmarshalTypeErrorHasFieldPath(t *testing.T) {
+	type Item struct {
+		Price int8 `json:"price"`
+	}
+	type Order struct {
+		Items []Item `json:"items"`
+	}
+	type Customer struct {
+		Orders map[string]Order `json:"orders"`
+	}
+	in := map[string]any{
+		"orders": map[string]any{
+			"abc": map[string]any{
+				"items": []any{map[string]any{"price": 1}, map[string]any{"price": 1000}},
+			},
+		},
+	}
+	var out Customer
+	err := ToStruct(in, &out)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("Expected an UnmarshalTypeError, got %v", err)
+	}
+	if typeErr.Struct != "Customer" || typeErr.Field != "orders.abc.items.1.price" {
+		t.Errorf("Got Struct %q and Field %q, expected Customer and orders.abc.items.1.price", typeErr.Struct, typeErr.Field)
+	}
+}
+
+func TestNestedUnmarshalTypeErrorNamesOutermostStruct(t *testing.T) {
+	type Item struct {
+		Price int `json:"price"`
+	}
+	type Order struct {
+		Items []Item `json:"items"`
+	}
+	type Top struct {
+		Orders []Order `json:"orders"`
+	}
+	type itemIn struct {
+		Price string `json:"price"`
+	}
+	type orderIn struct {
+		Items []itemIn `json:"items"`
+	}
+	in := struct {
+		Orders []orderIn `json:"orders"`
+	}{Orders: []orderIn{{Items: []itemIn{{Price: "x"}}}}}
+	var out Top
+	err := ToStruct(in, &out)
+	want := "json: cannot unmarshal string into Go struct field Top.orders.0.items.0.price of type int"
+	if err == nil || err.Error() != want {
+		t.Errorf("Got %v, expected %s", err, want)
+	}
+	// the same from decoded JSON
+	err = ToStruct(map[string]any{"orders": []any{map[string]any{"items": []any{map[string]any{"price": "x"}}}}}, &out)
+	if err == nil || err.Error() != want {
+		t.Errorf("Got %v, expected %s", err, want)
+	}
+}
+
+func TestCustomUnmarshalerErrorHasPath(t *testing.T) {
+	type Foo struct {
+		Bar struct {
+			Baz cantUnmarshal `json:"baz"`
+		} `json:"bar"`
+	}
+	var out Foo
+	err := ToStruct(map[string]any{"bar": map[string]any{"baz": 1}}, &out)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "bar.baz" {
+		t.Errorf("Expected a PathError at bar.baz, got %v", err)
+	}
+}
+
+func TestCollectErrors(t *testing.T) {
+	type Item struct {
+		Price int8   `json:"price"`
+		Name  string `json:"name"`
+	}
+	type Order struct {
+		Items []Item          `json:"items"`
+		Tags  map[string]uint `json:"tags"`
+		Note  string          `json:"note"`
+	}
+	in := map[string]any{
+		"items": []any{
+			map[string]any{"price": 1000, "name": "a"},
+			map[string]any{"price": 1, "name": 2},
+		},
+		"tags": map[string]any{"b": -1, "a": -2, "c": 3},
+		"note": "hello",
+	}
+	var out Order
+	err := ToStruct(in, &out, Options{CollectErrors: true})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	expected := Order{
+		Items: []Item{{Name: "a"}, {Price: 1}},
+		Tags:  map[string]uint{"a": 0, "b": 0, "c": 3},
+		Note:  "hello",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("Expected a joined error, got %v", err)
+	}
+	var fields []string
+	for _, e := range joined.Unwrap() {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(e, &typeErr) {
+			t.Fatalf("Expected an UnmarshalTypeError, got %v", e)
+		}
+		fields = append(fields, typeErr.Field)
+	}
+	// the map keys are sorted, so this is stable
+	expectedFields := []string{"items.0.price", "items.1.name", "tags.a", "tags.b"}
+	if len(fields) != len(expectedFields) {
+		t.Fatalf("Got errors at %v, expected %v", fields, expectedFields)
+	}
+	for _, field := range expectedFields {
+		found := false
+		for _, f := range fields {
+			found = found || f == field
+		}
+		if !found {
+			t.Errorf("Got errors at %v, expected %v", fields, expectedFields)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		var again Order
+		if err2 := ToStruct(in, &again, Options{CollectErrors: true}); err2.Error() != err.Error() {
+			t.Fatalf("Got nondeterministic errors:\n%v\n%v", err, err2)
+		}
+	}
+}
+
+func TestCollectErrorsSkipsUnsupportedValues(t *testing.T) {
+	in := map[string]any{"a": 1, "b": make(chan int), "c": complex(1, 2)}
+	var out map[string]any
+	err := ToStruct(in, &out, Options{CollectErrors: true})
+	var typeErr *json.UnsupportedTypeError
+	if !errors.As(err, &typeErr) {
+		t.Errorf("Expected an UnsupportedTypeError, got %v", err)
+	}
+	if out["a"] != 1.0 {
+		t.Errorf("Expected a to be converted, got %+v", out)
+	}
+	if _, ok := out["c"]; ok {
+		t.Errorf("Expected c to be skipped, got %+v", out)
+	}
+}
+
+func TestDisallowUnknownFields(t *testing.T) {
+	type Contact struct {
+		Email string `json:"email"`
+	}
+	type User struct {
+		Name    string  `json:"name"`
+		Contact Contact `json:"contact"`
+	}
+	in := map[string]any{
+		"name":    "bob",
+		"contact": map[string]any{"emial": "bob@example.com", "phone": "555"},
+	}
+	var out User
+	if err := ToStruct(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	err := ToStruct(in, &out, Options{DisallowUnknownFields: true})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Fatalf("Expected ErrUnknownField, got %v", err)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("Expected two errors, got %v", err)
+	}
+	var paths []string
+	for _, e := range joined.Unwrap() {
+		var pathErr *PathError
+		if errors.As(e, &pathErr) {
+			paths = append(paths, pathErr.Path)
+		}
+	}
+	if !reflect.DeepEqual(paths, []string{"contact.emial", "contact.phone"}) {
+		t.Errorf("Got paths %v", paths)
+	}
+
+	type Other struct {
+		Name  string `json:"name"`
+		Extra int    `json:"extra"`
+	}
+	var out2 Other
+	err = ToStruct(User{Name: "bob"}, &out2, Options{DisallowUnknownFields: true})
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "contact" || !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Expected an unknown field error at contact, got %v", err)
+	}
+	if out2.Name != "bob" {
+		t.Errorf("Expected known fields to be converted, got %+v", out2)
+	}
+}
+
+func TestUseNumber(t *testing.T) {
+	type Foo struct {
+		ID    uint64  `json:"id"`
+		Big   int64   `json:"big"`
+		Price float32 `json:"price"`
+	}
+	a := Foo{ID: math.MaxUint64, Big: 1<<53 + 1, Price: 0.1}
+	var b map[string]any
+	if err := ToStruct(a, &b, Options{UseNumber: true}); err != nil {
+		t.Fatal(err)
+	}
+	var c map[string]any
+	dec := json.NewDecoder(bytes.NewReader([]byte(toJson(a))))
+	dec.UseNumber()
+	if err := dec.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+
+	// and back again
+	var d, e Foo
+	err := ToStruct(b, &d)
+	err2 := toStructSlow(b, &e)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(d, a) || !reflect.DeepEqual(d, e) {
+		t.Errorf("Got %+v\nExpected %+v", d, e)
+	}
+}
+
+func TestUseNumberFastPath(t *testing.T) {
+	ints := map[string]int{"a": math.MaxInt64}
+	floats := map[string]float64{"b": 1.5}
+	var a, b map[string]any
+	var c, d any
+	for _, out := range []any{&a, &c} {
+		if err := ToStruct(ints, out, Options{UseNumber: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, out := range []any{&b, &d} {
+		if err := ToStruct(floats, out, Options{UseNumber: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a["a"] != json.Number("9223372036854775807") || c.(map[string]any)["a"] != json.Number("9223372036854775807") {
+		t.Errorf("Got %+v and %+v", a, c)
+	}
+	if b["b"] != json.Number("1.5") || d.(map[string]any)["b"] != json.Number("1.5") {
+		t.Errorf("Got %+v and %+v", b, d)
+	}
+
+	// marshalers go through encoding/json, which should also use numbers
+	var e any
+	if err := ToStruct(json.RawMessage(`{"a":18446744073709551615}`), &e, Options{UseNumber: true}); err != nil {
+		t.Fatal(err)
+	}
+	if e.(map[string]any)["a"] != json.Number("18446744073709551615") {
+		t.Errorf("Got %+v", e)
+	}
+}
+
+func TestPreserveIntegers(t *testing.T) {
+	type Foo struct {
+		ID    uint64 `json:"id"`
+		Big   int    `json:"big"`
+		Price float64
+		Any   any
+	}
+	a := Foo{ID: math.MaxUint64, Big: 1<<53 + 1, Price: 1.5, Any: []int8{-1}}
+	var b map[string]any
+	if err := ToStruct(a, &b, Options{PreserveIntegers: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"id": uint64(math.MaxUint64), "big": int64(1<<53 + 1), "Price": 1.5, "Any": []any{int64(-1)}}
+	if !reflect.DeepEqual(b, expected) {
+		t.Errorf("Got %+v\nExpected %+v", b, expected)
+	}
+
+	var c map[string]any
+	if err := ToStruct(map[string]int{"a": 1}, &c, Options{PreserveIntegers: true}); err != nil {
+		t.Fatal(err)
+	}
+	if c["a"] != int64(1) {
+		t.Errorf("Got %+v", c)
+	}
+}
+
+func TestConvertIntoExistingInterface(t *testing.T) {
+	type Foo struct {
+		A any `json:"a"`
+		B any `json:"b"`
+	}
+	type Bar struct {
+		X int `json:"x"`
+	}
+	in := map[string]any{"a": map[string]any{"x": 1}, "b": map[string]any{"x": 1}}
+	b := Foo{A: &Bar{X: 2}, B: Bar{X: 2}}
+	c := Foo{A: &Bar{X: 2}, B: Bar{X: 2}}
+	err := ToStruct(in, &b)
+	err2 := toStructSlow(in, &c)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+
+	type Baz struct {
+		S fmt.Stringer `json:"s"`
+	}
+	var d, e Baz
+	err = ToStruct(map[string]any{"s": "foo"}, &d)
+	err2 = toStructSlow(map[string]any{"s": "foo"}, &e)
+	if (err != nil) != (err2 != nil) {
+		t.Errorf("Got %+v\nExpected: %+v", err, err2)
+	}
+}
+
+type zeroer struct {
+	A int
+}
+
+func (z zeroer) IsZero() bool { return z.A == 1 }
+
+type ptrZeroer struct {
+	A int
+}
+
+func (z *ptrZeroer) IsZero() bool { return z.A == 1 }
+
+func TestOmitZero(t *testing.T) {
+	type Embedded struct {
+		E int `json:"e,omitzero"`
+	}
+	type Foo struct {
+		*Embedded
+		Time      time.Time  `json:"time,omitzero"`
+		TimePtr   *time.Time `json:"timePtr,omitzero"`
+		Int       int        `json:"int,omitzero"`
+		Slice     []int      `json:"slice,omitzero"`
+		Struct    struct{ A int }
+		Zeroer    zeroer       `json:"zeroer,omitzero"`
+		ZeroerPtr *zeroer      `json:"zeroerPtr,omitzero"`
+		PtrZeroer ptrZeroer    `json:"ptrZeroer,omitzero"`
+		Iface     fmt.Stringer `json:"iface,omitzero"`
+		Both      []int        `json:"both,omitempty,omitzero"`
+	}
+	for _, a := range []Foo{
+		{},
+		{Zeroer: zeroer{A: 1}, PtrZeroer: ptrZeroer{A: 1}, ZeroerPtr: &zeroer{A: 1}},
+		{Embedded: &Embedded{}, Zeroer: zeroer{A: 2}, PtrZeroer: ptrZeroer{A: 2}, ZeroerPtr: &zeroer{}, Slice: []int{}, Both: []int{}},
+		{Embedded: &Embedded{E: 1}, Time: time.Unix(1, 0).UTC(), TimePtr: &time.Time{}, Int: 1, Slice: []int{1}, Both: []int{1}},
+	} {
+		var b, c map[string]any
+		err := ToStruct(a, &b)
+		err2 := toStructSlow(a, &c)
+		if (err != nil) != (err2 != nil) {
+			t.Errorf("Got %+v\nExpected: %+v", err, err2)
+		}
+		if !reflect.DeepEqual(b, c) {
+			t.Errorf("Got %+v\nExpected %+v", b, c)
+		}
+	}
+}
+
+type v2Embedded struct {
+	E int `json:"e"`
+}
+
+type v2Tagged struct {
+	Name string         `json:"name,case:ignore"`
+	Rest map[string]any `json:",embed"`
+}
+
+type v2Formatted struct {
+	Duration time.Duration `json:"duration,format:sec"`
+}
+
+func TestSemanticsV2MatchesJSONv2(t *testing.T) {
+	if !jsonv2Available {
+		t.Skip("encoding/json/v2 isn't available")
+	}
+	type Foo struct {
+		Name   string            `json:"name"`
+		Tags   []string          `json:"tags"`
+		Attrs  map[string]string `json:"attrs"`
+		Count  int               `json:"count,omitempty"`
+		Note   *string           `json:"note,omitempty"`
+		Empty  []int             `json:"empty,omitempty"`
+		Hash   [4]byte           `json:"hash"`
+		Ptr    *int              `json:"ptr"`
+		Nested *v2Embedded       `json:"nested"`
+		*v2Embedded
+	}
+	empty := ""
+	one := 1
+	// built at runtime, since vet rejects this
+	duplicateNames := reflect.New(reflect.StructOf([]reflect.StructField{
+		{Name: "A", Type: reflect.TypeOf(0), Tag: `json:"x"`},
+		{Name: "B", Type: reflect.TypeOf(0), Tag: `json:"x"`},
+	})).Elem().Interface()
+	testCases := []struct {
+		in  any
+		out any
+	}{
+		{Foo{}, map[string]any(nil)},
+		{Foo{Name: "a", Count: 0, Note: &empty, Empty: []int{}, Hash: [4]byte{1, 2, 3, 4}, Ptr: &one, v2Embedded: &v2Embedded{E: 2}}, map[string]any(nil)},
+		{Foo{Tags: []string{"x"}, Attrs: map[string]string{"k": "v"}}, Foo{}},
+		{map[string]any{"name": "a", "Name": "b", "NAME": "c"}, Foo{}},
+		{map[string]any{"ptr": nil, "hash": "AQIDBA=="}, Foo{}},
+		{map[string]any{"hash": "AQID"}, Foo{}},
+		{[]byte(nil), []byte(nil)},
+		{[]byte(nil), ""},
+		{[]int(nil), any(nil)},
+		{map[string]int(nil), any(nil)},
+		{map[string]int(nil), map[string]int(nil)},
+		{[2]byte{1, 2}, any(nil)},
+		{[2]byte{1, 2}, []byte(nil)},
+		{[]byte{1, 2}, [2]byte{}},
+		{[]byte{1, 2}, [3]byte{}},
+		{[]int{1, 2}, [3]int{}},
+		{[]int{1, 2}, [2]byte{}},
+		{map[float64]int{1.5: 1}, any(nil)},
+		{map[string]int{"2.5": 1}, map[float64]int(nil)},
+		{"true", false},
+		{"\xff", ""},
+		{time.Second, any(nil)},
+		{struct{ C chan int }{}, any(nil)},
+		{struct{ a int }{}, any(nil)},
+		{duplicateNames, any(nil)},
+		{map[string]any{"NAME": "a", "other": true}, v2Tagged{}},
+		{v2Tagged{Name: "a", Rest: map[string]any{"other": true}}, any(nil)},
+		{map[string]any{"duration": 1.5}, v2Formatted{}},
+	}
+	for _, tc := range testCases {
+		out := reflect.New(reflect.TypeOf(&tc.out).Elem())
+		outSlow := reflect.New(reflect.TypeOf(&tc.out).Elem())
+		if tc.out != nil {
+			out = reflect.New(reflect.TypeOf(tc.out))
+			outSlow = reflect.New(reflect.TypeOf(tc.out))
+		}
+		err := ToStruct(tc.in, out.Interface(), Options{Semantics: SemanticsV2})
+		err2 := toStructSlowV2(tc.in, outSlow.Interface())
+		if (err != nil) != (err2 != nil) {
+			t.Errorf("%T(%v) -> %v: got error %v, expected %v", tc.in, tc.in, out.Type().Elem(), err, err2)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(out.Elem().Interface(), outSlow.Elem().Interface()) {
+			t.Errorf("%T(%v) -> %v: got %#v, expected %#v", tc.in, tc.in, out.Type().Elem(), out.Elem(), outSlow.Elem())
+		}
+	}
+}
+
+func TestSemanticsV2MergesIntoExistingValues(t *testing.T) {
+	if !jsonv2Available {
+		t.Skip("encoding/json/v2 isn't available")
+	}
+	type Foo struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+	in := map[string]any{"foo": map[string]any{"a": 1}, "bar": map[string]any{"b": 2}}
+	newOut := func() map[string]any {
+		return map[string]any{"foo": Foo{B: 3}, "bar": map[string]any{"a": 4}}
+	}
+	b, c := newOut(), newOut()
+	err := ToStruct(in, &b, Options{Semantics: SemanticsV2})
+	err2 := toStructSlowV2(in, &c)
+	if err != nil || err2 != nil {
+		t.Fatalf("Got %v and %v", err, err2)
+	}
+	if !reflect.DeepEqual(b, c) {
+		t.Errorf("Got %+v\nExpected %+v", b, c)
+	}
+}
+
+func TestSemanticsV2Unavailable(t *testing.T) {
+	if jsonv2Available {
+		t.Skip("encoding/json/v2 is available")
+	}
+	var out map[string]any
+	if err := ToStruct(map[string]any{"a": 1}, &out, Options{Semantics: SemanticsV2}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestPrefersExactFieldMatch(t *testing.T) {
+	type Foo struct {
+		ID    int `json:"ID"`
+		Lower int `json:"id"`
+		Other int
+	}
+	for _, in := range []any{
+		map[string]any{"Id": 1, "other": 2, "OTHER": 3},
+		struct {
+			A int `json:"other"`
+			B int `json:"OTHER"`
+			C int `json:"Id"`
+		}{1, 2, 3},
+	} {
+		// map iteration order is random, so try a few times
+		for i := 0; i < 20; i++ {
+			var b, c Foo
+			err := ToStruct(in, &b)
+			err2 := toStructSlow(in, &c)
+			if (err != nil) != (err2 != nil) {
+				t.Errorf("Got %+v\nExpected: %+v", err, err2)
+			}
+			if !reflect.DeepEqual(b, c) {
+				t.Fatalf("Got %+v\nExpected %+v", b, c)
+			}
+		}
+	}
+
+	// unlike json.Unmarshal, where the last key in sorted order wins, a key that matches exactly beats ones that don't
+	for _, reset := range []bool{false, true} {
+		var b Foo
+		if err := ToStruct(map[string]any{"ID": 1, "id": 2, "iD": 3, "Id": 4}, &b, Options{Reset: reset}); err != nil {
+			t.Fatal(err)
+		}
+		if expected := (Foo{ID: 1, Lower: 2}); b != expected {
+			t.Errorf("Got %+v\nExpected %+v with Reset %v", b, expected, reset)
+		}
+		var c struct{ ID int }
+		if err := ToStruct(map[string]any{"ID": 1, "id": 2}, &c, Options{Reset: reset}); err != nil {
+			t.Fatal(err)
+		}
+		if c.ID != 1 {
+			t.Errorf("Got %d, expected the exact match 1 with Reset %v", c.ID, reset)
+		}
+		var d struct {
+			ID int `json:"id"`
+		}
+		if err := ToStruct(map[string]any{"ID": 1, "id": 2}, &d, Options{Reset: reset}); err != nil {
+			t.Fatal(err)
+		}
+		if d.ID != 2 {
+			t.Errorf("Got %d, expected the exact match 2 with Reset %v", d.ID, reset)
+		}
+	}
+	var e struct{ ID int }
+	in := struct {
+		A int `json:"id"`
+		B int `json:"ID"`
+	}{2, 1}
+	if err := ToStruct(in, &e, Options{DisallowUnknownFields: true}); err != nil || e.ID != 1 {
+		t.Errorf("Got %+v, %v, expected the exact match 1 from a struct", e, err)
+	}
+	e.ID = 0
+	if _, err := MergePatch(map[string]any{"id": 2, "ID": 1}, &e); err != nil || e.ID != 1 {
+		t.Errorf("Got %+v, %v, expected the exact match 1 from MergePatch", e, err)
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	type Foo struct {
+		ID    int `json:"id"`
+		Name  string
+		Other string
+	}
+	in := map[string]any{"ID": 1, "name": "a", "Other": "b"}
+	var b Foo
+	if err := ToStruct(in, &b, Options{CaseSensitive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Foo{Other: "b"}); b != expected {
+		t.Errorf("Got %+v\nExpected %+v", b, expected)
+	}
+
+	var c Foo
+	err := ToStruct(in, &c, Options{CaseSensitive: true, DisallowUnknownFields: true})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Got %v, expected ErrUnknownField", err)
+	}
+}
+
+func TestUnicodeCaseFolding(t *testing.T) {
+	type Foo struct {
+		Kelvin int    `json:"k"`
+		Size   int    `json:"size"`
+		Straße string `json:"straße"`
+		Sigma  int    `json:"σ"`
+	}
+	for _, in := range []any{
+		map[string]any{"K": 1, "ſize": 2, "STRASSE": "a", "Σ": 3},
+		map[string]any{"K": 1, "SIZE": 2, "STRAẞE": "a", "ς": 3},
+		struct {
+			A int    `json:"K"`
+			B int    `json:"ſIZE"`
+			C string `json:"strasse"`
+			D int    `json:"Σ"`
+		}{1, 2, "a", 3},
+	} {
+		var b, c Foo
+		err := ToStruct(in, &b)
+		err2 := toStructSlow(in, &c)
+		if (err != nil) != (err2 != nil) {
+			t.Errorf("Got %+v\nExpected: %+v", err, err2)
+		}
+		if b != c {
+			t.Errorf("Got %+v\nExpected %+v", b, c)
+		}
+	}
+}
+
+func TestTagNames(t *testing.T) {
+	type Inner struct {
+		Value int `api:"value,omitempty" json:"v"`
+	}
+	type Foo struct {
+		ID      int    `api:"id" json:"identifier"`
+		Name    string `json:"name"`
+		Secret  string `api:"-" json:"secret"`
+		Ignored string `goloose:"-" api:"ignored"`
+		Inner   Inner  `api:"inner,omitempty"`
+	}
+	in := Foo{ID: 1, Name: "a", Secret: "b", Ignored: "c"}
+
+	var out map[string]any
+	if err := ToStruct(in, &out, Options{TagNames: []string{"api"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"id": 1.0, "Name": "a", "ignored": "c", "inner": map[string]any{}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	out = nil
+	if err := ToStruct(in, &out, Options{TagNames: []string{"goloose", "api", "json"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]any{"id": 1.0, "name": "a", "inner": map[string]any{}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	// the same type with the default tags shouldn't see the fields cached for other tags
+	out = nil
+	if err := ToStruct(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]any{"identifier": 1.0, "name": "a", "secret": "b", "Ignored": "c", "Inner": map[string]any{"v": 0.0}}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	var foo Foo
+	if err := ToStruct(map[string]any{"ID": 2, "identifier": 3, "secret": "d", "inner": map[string]any{"value": 4}}, &foo, Options{TagNames: []string{"api"}}); err != nil {
+		t.Fatal(err)
+	}
+	if expectedFoo := (Foo{ID: 2, Inner: Inner{Value: 4}}); foo != expectedFoo {
+		t.Errorf("Got %+v\nExpected %+v", foo, expectedFoo)
+	}
+}
+
+func TestTagNamesSemanticsV2(t *testing.T) {
+	if !jsonv2Available {
+		t.Skip("encoding/json/v2 isn't available")
+	}
+	type Inner struct {
+		Value int `api:"value,omitzero" json:"v"`
+	}
+	type Foo struct {
+		Inner Inner  `api:"inner,omitempty"`
+		Rest  string `api:"rest" json:",embed"`
+	}
+	var out map[string]any
+	if err := ToStruct(Foo{Rest: "a"}, &out, Options{Semantics: SemanticsV2, TagNames: []string{"api"}}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]any{"rest": "a"}; !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+}
+
+type funcNameMapper func(string) string
+
+func (f funcNameMapper) MapName(fieldName string) string { return f(fieldName) }
+
+func TestNameMapper(t *testing.T) {
+	for _, tc := range []struct {
+		mapper   NameMapper
+		in       string
+		expected string
+	}{
+		{SnakeCase, "UserID", "user_id"},
+		{SnakeCase, "HTTPServer", "http_server"},
+		{SnakeCase, "ID", "id"},
+		{SnakeCase, "Address2Line", "address2_line"},
+		{SnakeCase, "Already_Snake", "already_snake"},
+		{KebabCase, "UserID", "user-id"},
+		{CamelCase, "UserID", "userId"},
+		{CamelCase, "HTTPServer", "httpServer"},
+		{ScreamingSnakeCase, "UserID", "USER_ID"},
+		{ScreamingSnakeCase, "Name", "NAME"},
+	} {
+		if got := tc.mapper.MapName(tc.in); got != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.in, got, tc.expected)
+		}
+	}
+
+	type Embedded struct {
+		CreatedAt string
+	}
+	type User struct {
+		UserID    int
+		FirstName string `json:"first"`
+		LastName  string `json:",omitempty"`
+		Embedded
+	}
+	in := User{UserID: 1, FirstName: "a", LastName: "b", Embedded: Embedded{"c"}}
+	var out map[string]any
+	if err := ToStruct(in, &out, Options{NameMapper: SnakeCase}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"user_id": 1.0, "first": "a", "last_name": "b", "created_at": "c"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	var user User
+	if err := ToStruct(expected, &user, Options{NameMapper: SnakeCase}); err != nil {
+		t.Fatal(err)
+	}
+	if user != in {
+		t.Errorf("Got %+v\nExpected %+v", user, in)
+	}
+
+	type Row struct {
+		ID   int    `json:"user_id"`
+		Name string `json:"FIRST_NAME"`
+	}
+	var row Row
+	if err := ToStruct(in, &row, Options{NameMapper: ScreamingSnakeCase}); err != nil {
+		t.Fatal(err)
+	}
+	if expectedRow := (Row{ID: 1}); row != expectedRow {
+		t.Errorf("Got %+v\nExpected %+v", row, expectedRow)
+	}
+
+	// the same type without a NameMapper shouldn't see the fields cached for one
+	out = nil
+	if err := ToStruct(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]any{"UserID": 1.0, "first": "a", "LastName": "b", "CreatedAt": "c"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	out = nil
+	if err := ToStruct(in, &out, Options{NameMapper: funcNameMapper(strings.ToLower)}); err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]any{"userid": 1.0, "first": "a", "lastname": "b", "createdat": "c"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+}
+
+type testDecimal struct {
+	units int64
+	scale int
+}
+
+type testUUID [16]byte
+
+func init() {
+	RegisterConverter(func(d testDecimal) (float64, error) {
+		return float64(d.units) / math.Pow10(d.scale), nil
+	})
+	RegisterConverter(func(id testUUID) (string, error) {
+		if id == (testUUID{}) {
+			return "", errors.New("empty UUID")
+		}
+		return fmt.Sprintf("%x", id[:]), nil
+	})
+}
+
+func TestRegisterConverter(t *testing.T) {
+	type In struct {
+		Price  testDecimal
+		Prices []*testDecimal
+		Any    any
+		ID     testUUID
+	}
+	type Out struct {
+		Price  float64
+		Prices []float64
+		Any    float64
+		ID     string
+	}
+	in := In{
+		Price:  testDecimal{1234, 2},
+		Prices: []*testDecimal{{5, 1}, nil},
+		Any:    testDecimal{7, 0},
+		ID:     testUUID{1, 2},
+	}
+	var out Out
+	if err := ToStruct(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := Out{Price: 12.34, Prices: []float64{0.5, 0}, Any: 7, ID: "01020000000000000000000000000000"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %+v\nExpected %+v", out, expected)
+	}
+
+	// Options.Converters take priority over registered ones
+	var price float64
+	opts := Options{Converters: []TypeConverter{ConverterFunc(func(d testDecimal) (float64, error) { return -1, nil })}}
+	if err := ToStruct(testDecimal{1, 0}, &price, opts); err != nil {
+		t.Fatal(err)
+	}
+	if price != -1 {
+		t.Errorf("Got %v, expected -1", price)
+	}
+
+	var m map[string]string
+	err := ToStruct(map[string]testUUID{"a": {}}, &m)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "a" || pathErr.Err.Error() != "empty UUID" {
+		t.Errorf("Got %v, expected the converter's error at a", err)
+	}
+}
+
+func TestConverterPanicsReturnErrors(t *testing.T) {
+	boom := ConverterFunc(func(d testDecimal) (float64, error) { panic("convert boom") })
+	type In struct {
+		Prices []testDecimal `json:"prices"`
+	}
+	var out struct {
+		Prices []float64 `json:"prices"`
+	}
+	err := ToStruct(In{Prices: []testDecimal{{}, {}}}, &out, Options{Converters: []TypeConverter{boom}})
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "prices.0" || !strings.Contains(err.Error(), "convert boom") {
+		t.Errorf("Expected a PathError at prices.0, got %v", err)
+	}
+}
+
+func TestConvertersRunAfterTransforms(t *testing.T) {
+	// the transform turns the input into a testDecimal, which the registered converter handles
+	opts := Options{Transforms: []TransformFunc{func(v any) any {
+		if n, ok := v.(int); ok {
+			return testDecimal{int64(n), 1}
+		}
+		return v
+	}}}
+	var f float64
+	if err := ToStruct(25, &f, opts); err != nil {
+		t.Fatal(err)
+	}
+	if f != 2.5 {
+		t.Errorf("Got %v, expected 2.5", f)
+	}
+}
+
+// cents converts to and from goloose as a number of cents, and to and from JSON as a decimal string.
+type cents int64
+
+func (c cents) GolooseValue() any { return int64(c) }
+
+func (c *cents) SetGolooseValue(v any) error {
+	f, ok := v.(float64)
+	if !ok {
+		return fmt.Errorf("can't set cents from %T", v)
+	}
+	*c = cents(f)
+	return nil
+}
+
+func (c cents) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("MarshalJSON shouldn't be called")
+}
+
+func (c *cents) UnmarshalJSON(b []byte) error {
+	return errors.New("UnmarshalJSON shouldn't be called")
+}
+
+// centsCopy only knows how to take the int64 that cents hands over.
+type centsCopy struct {
+	n int64
+}
+
+func (c *centsCopy) SetGolooseValue(v any) error {
+	c.n = v.(int64)
+	return nil
+}
+
+func TestGolooseValue(t *testing.T) {
+	type Prices struct {
+		Price  cents
+		Others []*cents
+	}
+	var m map[string]any
+	if err := ToStruct(Prices{Price: 150, Others: []*cents{new(cents), nil}}, &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"Price": 150.0, "Others": []any{0.0, nil}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("Got %+v\nExpected %+v", m, expected)
+	}
+
+	var prices Prices
+	if err := ToStruct(map[string]any{"Price": 250, "Others": []int{1}}, &prices); err != nil {
+		t.Fatal(err)
+	}
+	if prices.Price != 250 || len(prices.Others) != 1 || *prices.Others[0] != 1 {
+		t.Errorf("Got %+v", prices)
+	}
+
+	var c centsCopy
+	if err := ToStruct(cents(350), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.n != 350 {
+		t.Errorf("Got %v, expected 350", c.n)
+	}
+
+	var copies struct{ A *centsCopy }
+	if err := ToStruct(map[string]any{"A": cents(450)}, &copies); err != nil {
+		t.Fatal(err)
+	}
+	if copies.A == nil || copies.A.n != 450 {
+		t.Errorf("Got %+v, expected 450", copies.A)
+	}
+
+	err := ToStruct(map[string]any{"Price": "1.50"}, &prices)
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "Price" {
+		t.Errorf("Got %v, expected SetGolooseValue's error at Price", err)
+	}
+}
+
+// textID is text marshaled with a prefix, and can't be unmarshaled from text without it.
+type textID string
+
+func (id textID) MarshalText() ([]byte, error) {
+	if id == "" {
+		return nil, errors.New("empty ID")
+	}
+	return []byte("id-" + id), nil
+}
+
+func (id *textID) UnmarshalText(b []byte) error {
+	s, ok := strings.CutPrefix(string(b), "id-")
+	if !ok {
+		return fmt.Errorf("bad ID %q", b)
+	}
+	*id = textID(s)
+	return nil
+}
+
+// invalidText marshals to invalid UTF-8, which json.Marshal replaces.
+type invalidText struct{}
+
+func (invalidText) MarshalText() ([]byte, error) { return []byte("a\xffb"), nil }
+
+func TestTextMarshalers(t *testing.T) {
+	addr := netip.MustParseAddr("10.0.0.1")
+	id := textID("abc")
+	for i, tc := range []struct {
+		in  any
+		out func() any
+	}{
+		{addr, func() any { return new(string) }},
+		{&addr, func() any { return new(any) }},
+		{"10.0.0.1", func() any { return new(netip.Addr) }},
+		{map[string]any{"addr": "::1", "id": "id-x"}, func() any { return new(textHosts) }},
+		{textHosts{Addr: addr, ID: id}, func() any { return new(stringHosts) }},
+		{textHosts{Addr: addr, ID: id}, func() any { return new(textHosts) }},
+		{textHosts{Addr: addr, ID: id}, func() any { return new(map[string]any) }},
+		{stringHosts{Addr: "10.0.0.1", ID: "id-y"}, func() any { return new(textHosts) }},
+		{struct{ ID *textID }{}, func() any { return new(struct{ ID *string }) }},
+		{struct{ ID any }{&id}, func() any { return new(struct{ ID []byte }) }},
+		{id, func() any { return new(int) }},
+		{"abc", func() any { return new(textID) }},
+		{textID(""), func() any { return new(string) }},
+		{"not an address", func() any { return new(netip.Addr) }},
+		{invalidText{}, func() any { return new(string) }},
+		{"a\xffb", func() any { return new(textID) }},
+	} {
+		out, outSlow := tc.out(), tc.out()
+		err := ToStruct(tc.in, out)
+		err2 := toStructSlow(tc.in, outSlow)
+		if (err == nil) != (err2 == nil) {
+			t.Errorf("%d: got error %v, expected %v", i, err, err2)
+		}
+		if !reflect.DeepEqual(out, outSlow) {
+			t.Errorf("%d: got %+v\nExpected %+v", i, reflect.ValueOf(out).Elem(), reflect.ValueOf(outSlow).Elem())
+		}
+		if !jsonv2Available {
+			continue
+		}
+		out, outSlow = tc.out(), tc.out()
+		err = ToStruct(tc.in, out, Options{Semantics: SemanticsV2})
+		err2 = toStructSlowV2(tc.in, outSlow)
+		if (err == nil) != (err2 == nil) {
+			t.Errorf("%d: got error %v, expected %v with SemanticsV2", i, err, err2)
+		}
+		if !reflect.DeepEqual(out, outSlow) {
+			t.Errorf("%d: got %+v\nExpected %+v with SemanticsV2", i, reflect.ValueOf(out).Elem(), reflect.ValueOf(outSlow).Elem())
+		}
+	}
+}
+
+type textIntID int
+
+func (id textIntID) MarshalText() ([]byte, error) {
+	return []byte("id-" + strconv.Itoa(int(id))), nil
+}
+
+func (id *textIntID) UnmarshalText(b []byte) error {
+	s, ok := strings.CutPrefix(string(b), "id-")
+	if !ok {
+		return fmt.Errorf("bad ID %q", b)
+	}
+	n, err := strconv.Atoi(s)
+	*id = textIntID(n)
+	return err
+}
+
+func TestTextMarshalerMapKeys(t *testing.T) {
+	var strs map[string]int
+	if err := ToStruct(map[textIntID]int{3: 1}, &strs); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"id-3": 1}; !reflect.DeepEqual(strs, want) {
+		t.Errorf("Got %v, expected %v", strs, want)
+	}
+	var ids map[textIntID]int
+	if err := ToStruct(map[string]int{"id-3": 1}, &ids); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[textIntID]int{3: 1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Got %v, expected %v", ids, want)
+	}
+
+	for i, tc := range []struct {
+		in  any
+		out func() any
+	}{
+		{map[textIntID]int{3: 1, 10: 2}, func() any { return new(map[string]int) }},
+		{map[textIntID]int{3: 1}, func() any { return new(map[textIntID]int) }},
+		{map[string]int{"id-3": 1}, func() any { return new(map[textIntID]int) }},
+		{map[string]int{"3": 1}, func() any { return new(map[textIntID]int) }},
+		// json.Unmarshal calls UnmarshalText for keys of string types, and json.Marshal does too unless it's the original implementation
+		{map[textID]int{"a": 1}, func() any { return new(map[string]int) }},
+		{map[string]int{"id-a": 1}, func() any { return new(map[textID]int) }},
+		{map[textID]int{"a": 1}, func() any { return new(map[textID]int) }},
+		{map[textIntID]int{3: 1}, func() any { return new(any) }},
+		{map[string]any{"id-3": map[textIntID]int{4: 1}}, func() any { return new(map[textIntID]map[string]int) }},
+	} {
+		out, outSlow := tc.out(), tc.out()
+		err := ToStruct(tc.in, out)
+		err2 := toStructSlow(tc.in, outSlow)
+		if (err == nil) != (err2 == nil) {
+			t.Errorf("%d: got error %v, expected %v", i, err, err2)
+		}
+		if !reflect.DeepEqual(out, outSlow) {
+			t.Errorf("%d: got %+v\nExpected %+v", i, reflect.ValueOf(out).Elem(), reflect.ValueOf(outSlow).Elem())
+		}
+	}
+}
+
+type lateConverted struct{ n int }
+
+func TestPlansSeeNewConverters(t *testing.T) {
+	var out struct{ A string }
+	in := struct{ A lateConverted }{lateConverted{1}}
+	_ = ToStruct(in, &out)
+	if out.A != "" {
+		t.Fatalf("Got %q before registering a converter", out.A)
+	}
+	RegisterConverter(func(l lateConverted) (string, error) { return strconv.Itoa(l.n), nil })
+	if err := ToStruct(in, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.A != "1" {
+		t.Errorf("Got %q, expected 1", out.A)
+	}
+}
+
+func TestNewConverter(t *testing.T) {
+	type Foo struct {
+		A string
+		B *int
+		C []float64
+	}
+	type Foo2 struct {
+		A any
+		B float64
+		C []int
+	}
+	c, err := NewConverter[Foo, Foo2](Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := 2
+	in := Foo{A: "a", B: &b, C: []float64{1, 2.5}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := c.Convert(in)
+			var expected Foo2
+			expectedErr := ToStruct(in, &expected)
+			if (err == nil) != (expectedErr == nil) {
+				t.Errorf("Got %v, expected %v", err, expectedErr)
+			}
+			if !reflect.DeepEqual(out, expected) {
+				t.Errorf("Got %+v\nExpected %+v", out, expected)
+			}
+		}()
+	}
+	wg.Wait()
+
+	anyConverter, err := NewConverter[any, map[string]int](Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]int{"z": 1}
+	if err := anyConverter.ConvertInto(nil, &out); err != nil || len(out) != 1 {
+		t.Errorf("Got %v, %v, expected nil to leave the map alone", out, err)
+	}
+	if err := anyConverter.ConvertInto(map[string]any{"a": 1}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[string]int{"a": 1, "z": 1}; !reflect.DeepEqual(out, expected) {
+		t.Errorf("Got %v, expected %v", out, expected)
+	}
+
+	for _, opts := range []Options{{Semantics: 7}, {TagNames: []string{"json", ""}}, {Converters: []TypeConverter{{}}}} {
+		if _, err := NewConverter[Foo, Foo2](opts); err == nil {
+			t.Errorf("Expected an error for options %+v", opts)
+		}
+	}
+}
+
+func TestNewConverterIncompatibleTypes(t *testing.T) {
+	check := func(name string, err error, incompatible bool) {
+		t.Helper()
+		var typeErr *json.UnmarshalTypeError
+		if incompatible != errors.As(err, &typeErr) {
+			t.Errorf("%s: got error %v, expected incompatible to be %v", name, err, incompatible)
+		}
+	}
+	_, err := NewConverter[struct{ A int }, string](Options{})
+	check("struct into string", err, true)
+	_, err = NewConverter[*int, **bool](Options{})
+	check("number into bool", err, true)
+	_, err = NewConverter[[]int, map[string]int](Options{})
+	check("array into object", err, true)
+	_, err = NewConverter[string, [4]byte](Options{})
+	check("string into byte array", err, true)
+	_, err = NewConverter[string, float64](Options{})
+	check("string into float64", err, true)
+
+	_, err = NewConverter[string, float64](Options{StringToFloat64: true})
+	check("string into float64 with StringToFloat64", err, false)
+	_, err = NewConverter[string, []byte](Options{})
+	check("string into byte slice", err, false)
+	_, err = NewConverter[string, []textByte](Options{})
+	check("string into slice of byte text marshalers", err, false)
+	_, err = NewConverter[string, bool](Options{})
+	check("string into bool", err, false)
+	if jsonv2Available {
+		_, err = NewConverter[string, bool](Options{Semantics: SemanticsV2})
+		check("string into bool with SemanticsV2", err, true)
+		_, err = NewConverter[string, [4]byte](Options{Semantics: SemanticsV2})
+		check("string into byte array with SemanticsV2", err, false)
+	}
+	_, err = NewConverter[time.Time, string](Options{})
+	check("time into string", err, false)
+	_, err = NewConverter[netip.Addr, string](Options{})
+	check("text marshaler into string", err, false)
+	_, err = NewConverter[any, string](Options{})
+	check("interface into string", err, false)
+	_, err = NewConverter[int, json.Number](Options{})
+	check("number into json.Number", err, false)
+	_, err = NewConverter[testDecimal, float64](Options{})
+	check("registered converter", err, false)
+	_, err = NewConverter[[]int, map[string]int](Options{Transforms: []TransformFunc{func(i any) any { return i }}})
+	check("transforms", err, false)
+
+	// the check doesn't count null, which ToStruct converts into anything
+	_, err = NewConverter[*int, string](Options{})
+	check("pointer to number into string", err, true)
+	if _, err := ConvertTo[string]((*int)(nil)); err != nil {
+		t.Errorf("Got %v converting a nil *int into a string", err)
+	}
+	_, err = NewConverter[[]byte, []int](Options{})
+	check("byte slice into int slice", err, true)
+	if _, err := ConvertTo[[]int]([]byte(nil)); err != nil {
+		t.Errorf("Got %v converting a nil []byte into an []int", err)
+	}
+}
+
+// textByte is a byte that's encoded as text, so slices of them aren't base64 strings when they're marshaled.
+type textByte byte
+
+func (b textByte) MarshalText() ([]byte, error) { return []byte{byte(b)}, nil }
+
+func TestNewConverterAcceptsStrings(t *testing.T) {
+	// everything NewConverter lets through for strings should convert
+	boolConverter, err := NewConverter[string, bool](Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := boolConverter.Convert("TRUE"); err != nil || !b {
+		t.Errorf("Got %v, %v, expected true", b, err)
+	}
+	floatConverter, err := NewConverter[string, float64](Options{StringToFloat64: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := floatConverter.Convert("2.5"); err != nil || f != 2.5 {
+		t.Errorf("Got %v, %v, expected 2.5", f, err)
+	}
+	bytesConverter, err := NewConverter[string, []textByte](Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := bytesConverter.Convert("AQI="); err != nil || !reflect.DeepEqual(b, []textByte{1, 2}) {
+		t.Errorf("Got %v, %v, expected [1 2]", b, err)
+	}
+	if !jsonv2Available {
+		return
+	}
+	arrayConverter, err := NewConverter[string, [2]byte](Options{Semantics: SemanticsV2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err := arrayConverter.Convert("AQI="); err != nil || a != [2]byte{1, 2} {
+		t.Errorf("Got %v, %v, expected [1 2]", a, err)
+	}
+}
+
+type patchAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip,omitempty"`
+}
+
+type patchTarget struct {
+	Name    string            `json:"name"`
+	Age     int               `json:"age"`
+	Tags    []string          `json:"tags"`
+	Address *patchAddress     `json:"address"`
+	Labels  map[string]string `json:"labels"`
+	Meta    map[string]any    `json:"meta"`
+	Extra   any               `json:"extra"`
+	Updated time.Time         `json:"updated"`
+}
+
+// mergePatchJSON is RFC 7386's MergePatch, on decoded JSON.
+func mergePatchJSON(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+	for name, val := range patchObj {
+		if val == nil {
+			delete(targetObj, name)
+		} else {
+			targetObj[name] = mergePatchJSON(targetObj[name], val)
+		}
+	}
+	return targetObj
+}
+
+func TestMergePatch(t *testing.T) {
+	newTarget := func() patchTarget {
+		return patchTarget{
+			Name:    "Ann",
+			Age:     40,
+			Tags:    []string{"a", "b"},
+			Address: &patchAddress{City: "Paris", Zip: "75001"},
+			Labels:  map[string]string{"team": "x", "tier": "1"},
+			Meta:    map[string]any{"nested": map[string]any{"a": 1.0, "b": "two"}, "n": 1.0},
+			Extra:   map[string]any{"k": "v"},
+			Updated: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+	}
+	for _, tc := range []struct {
+		name    string
+		target  func() patchTarget
+		patch   string
+		changed []string
+	}{
+		{"absent fields are untouched", newTarget, `{"age": 41}`, []string{"age"}},
+		{"null clears", newTarget, `{"name": null, "address": null, "tags": null}`, []string{"address", "name", "tags"}},
+		{"same values aren't changes", newTarget, `{"name": "Ann", "address": {"city": "Paris"}, "labels": {"team": "x"}}`, nil},
+		{"nested objects merge", newTarget, `{"address": {"city": "Lyon"}, "meta": {"nested": {"a": null, "c": true}}}`, []string{"address.city", "meta.nested.a", "meta.nested.c"}},
+		{"arrays are replaced", newTarget, `{"tags": ["c"]}`, []string{"tags"}},
+		{"map entries", newTarget, `{"labels": {"team": null, "missing": null, "new": "y"}}`, []string{"labels.new", "labels.team"}},
+		{"interface holding an object", newTarget, `{"extra": {"k": null, "j": [1]}}`, []string{"extra.j", "extra.k"}},
+		{"object replaces a scalar", newTarget, `{"meta": {"n": {"x": 1}}}`, []string{"meta.n.x"}},
+		{"custom unmarshalers are replaced", newTarget, `{"updated": "2025-01-01T00:00:00Z"}`, []string{"updated"}},
+		{"into zero values", func() patchTarget { return patchTarget{} }, `{"address": {"zip": "1"}, "labels": {}, "meta": {"a": {}}, "extra": {}}`, []string{"address.zip", "extra", "labels", "meta.a"}},
+		{"empty patch", newTarget, `{}`, nil},
+	} {
+		var patch any
+		if err := json.Unmarshal([]byte(tc.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		got := tc.target()
+		changed, err := MergePatch(patch, &got)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(changed, tc.changed) {
+			t.Errorf("%s: changed %q, expected %q", tc.name, changed, tc.changed)
+		}
+
+		var doc any
+		if err := json.Unmarshal([]byte(toJson(tc.target())), &doc); err != nil {
+			t.Fatal(err)
+		}
+		var want patchTarget
+		if err := json.Unmarshal([]byte(toJson(mergePatchJSON(doc, patch))), &want); err != nil {
+			t.Fatal(err)
+		}
+		if toJson(got) != toJson(want) {
+			t.Errorf("%s: got %s, expected %s", tc.name, toJson(got), toJson(want))
+		}
+	}
+}
+
+func TestMergePatchNonObjects(t *testing.T) {
+	n := 1
+	changed, err := MergePatch("two", &n)
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || n != 1 || changed != nil {
+		t.Errorf("got %v, %q, %v, expected an UnmarshalTypeError", n, changed, err)
+	}
+
+	var anything any = map[string]any{"a": 1.0}
+	changed, err = MergePatch([]int{1, 2}, &anything)
+	if err != nil || !reflect.DeepEqual(changed, []string{""}) || !reflect.DeepEqual(anything, []any{1.0, 2.0}) {
+		t.Errorf("got %v, %q, %v, expected the array to replace everything", anything, changed, err)
+	}
+	changed, err = MergePatch(nil, &anything)
+	if err != nil || !reflect.DeepEqual(changed, []string{""}) || anything != nil {
+		t.Errorf("got %v, %q, %v, expected null to clear everything", anything, changed, err)
+	}
+
+	if _, err := MergePatch(map[string]any{}, patchTarget{}); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
+
+func TestMergePatchStructPatch(t *testing.T) {
+	type patchBody struct {
+		Name    *string        `json:"name,omitempty"`
+		Age     *int           `json:"age,omitempty"`
+		Address *patchAddress  `json:"address"`
+		Labels  map[string]any `json:"labels,omitempty"`
+	}
+	name := "Bob"
+	target := patchTarget{Name: "Ann", Age: 40, Address: &patchAddress{City: "Paris"}, Labels: map[string]string{"team": "x"}}
+	changed, err := MergePatch(patchBody{Name: &name, Labels: map[string]any{"team": nil}}, &target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := patchTarget{Name: "Bob", Age: 40, Labels: map[string]string{}}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("got %+v, expected %+v", target, want)
+	}
+	if !reflect.DeepEqual(changed, []string{"address", "labels.team", "name"}) {
+		t.Errorf("got changes %q", changed)
+	}
+}
+
+func TestMergePatchErrors(t *testing.T) {
+	target := patchTarget{Name: "Ann", Age: 40}
+	changed, err := MergePatch(map[string]any{"age": "old", "name": "Bob", "nope": 1}, &target, Options{DisallowUnknownFields: true, CollectErrors: true})
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field != "age" {
+		t.Errorf("got %v, expected an UnmarshalTypeError for age", err)
+	}
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("got %v, expected ErrUnknownField", err)
+	}
+	if target.Name != "Bob" || target.Age != 40 || !reflect.DeepEqual(changed, []string{"name"}) {
+		t.Errorf("got %+v and changes %q, expected only the name to change", target, changed)
+	}
+
+	labels := map[string]map[string]int{"a": {"x": 1}}
+	changed, err = MergePatch(map[string]any{"a": map[string]any{"x": 2, "y": "bad"}}, &labels)
+	if !errors.As(err, &typeErr) || labels["a"]["x"] != 2 || !reflect.DeepEqual(changed, []string{"a.x"}) {
+		t.Errorf("got %v, %v and changes %q, expected x to change and y to fail", labels, err, changed)
+	}
+
+	selfMap := map[string]any{}
+	selfMap["self"] = selfMap
+	var anything any
+	var valueErr *json.UnsupportedValueError
+	if _, err := MergePatch(selfMap, &anything); !errors.As(err, &valueErr) {
+		t.Errorf("got %v, expected a cycle to be reported", err)
+	}
+}
+
+type resetInner struct {
+	X int            `json:"x"`
+	M map[string]int `json:"m"`
+}
+
+type ResetEmbedded struct {
+	E string `json:"e"`
+	F string `json:"f"`
+}
+
+type resetValues struct {
+	V int `json:"v"`
+}
+
+type resetTarget struct {
+	*ResetEmbedded
+	resetValues
+	Name     string         `json:"name"`
+	Count    int            `json:"count"`
+	Items    []resetInner   `json:"items"`
+	Labels   map[string]int `json:"labels"`
+	Ptr      *resetInner    `json:"ptr"`
+	Any      any            `json:"any"`
+	Arr      [3]int         `json:"arr"`
+	When     time.Time      `json:"when"`
+	Ignored  string         `json:"-"`
+	internal string
+}
+
+func TestReset(t *testing.T) {
+	prefilled := func() resetTarget {
+		return resetTarget{
+			ResetEmbedded: &ResetEmbedded{E: "old", F: "old"},
+			resetValues:   resetValues{V: 1},
+			Name:          "old",
+			Count:         7,
+			Items:         []resetInner{{X: 1, M: map[string]int{"old": 1}}, {X: 2}, {X: 3}},
+			Labels:        map[string]int{"old": 1, "keep": 2},
+			Ptr:           &resetInner{X: 9, M: map[string]int{"old": 1}},
+			Any:           &resetInner{X: 9},
+			Arr:           [3]int{1, 2, 3},
+			When:          time.Now(),
+			Ignored:       "old",
+			internal:      "old",
+		}
+	}
+	for i, in := range []any{
+		map[string]any{},
+		map[string]any{"name": "new", "items": []any{map[string]any{"m": map[string]any{"new": 1}}}, "labels": map[string]any{"keep": 3}},
+		map[string]any{"e": "new", "ptr": map[string]any{"x": 1}, "any": map[string]any{"x": 1}, "arr": []any{4}},
+		map[string]any{"items": []any{nil, map[string]any{}, map[string]any{"x": 5}, map[string]any{"x": 6}}, "labels": nil, "ptr": nil},
+		map[string]any{"items": []any{}, "labels": map[string]any{}, "when": "2024-01-02T03:04:05Z", "count": "wrong type"},
+		struct {
+			Name  string       `json:"name,omitempty"`
+			Items []resetInner `json:"items"`
+			Ptr   *resetInner  `json:"ptr"`
+		}{Items: []resetInner{{X: 1}}},
+		resetTarget{ResetEmbedded: &ResetEmbedded{F: "new"}, Any: []int{1}},
+		nil,
+		(*resetTarget)(nil),
+	} {
+		for _, semantics := range []Semantics{SemanticsV1, SemanticsV2} {
+			if semantics == SemanticsV2 && !jsonv2Available {
+				continue
+			}
+			want := resetTarget{internal: "old"} // unexported fields are left alone
+			wantErr := ToStruct(in, &want, Options{Semantics: semantics})
+			got := prefilled()
+			gotErr := ToStruct(in, &got, Options{Semantics: semantics, Reset: true})
+			if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+				t.Errorf("%d with semantics %d: got error %v, expected %v", i, semantics, gotErr, wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%d with semantics %d: got %+v, expected %+v", i, semantics, got, want)
+			}
+		}
+	}
+}
+
+func TestResetNull(t *testing.T) {
+	// null leaves a zero value as it is, so Reset zeroes out
+	out := resetTarget{Name: "old", Labels: map[string]int{"old": 1}, internal: "old"}
+	if err := ToStruct(nil, &out, Options{Reset: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, resetTarget{internal: "old"}) {
+		t.Errorf("Got %+v, expected a zero value", out)
+	}
+	c, err := NewConverter[*resetTarget, resetTarget](Options{Reset: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = resetTarget{Name: "old", internal: "old"}
+	if err := c.ConvertInto(nil, &out); err != nil || !reflect.DeepEqual(out, resetTarget{internal: "old"}) {
+		t.Errorf("Got %+v, %v, expected a zero value from Converter", out, err)
+	}
+}
+
+func TestResetReusesStorage(t *testing.T) {
+	items := make([]resetInner, 3, 10)
+	items[1].X, items[2].X = 2, 3
+	labels := map[string]int{"old": 1}
+	ptr := &resetInner{X: 1}
+	out := resetTarget{Items: items, Labels: labels, Ptr: ptr}
+	in := map[string]any{"items": []any{map[string]any{"x": 1}}, "labels": map[string]any{"new": 1}, "ptr": map[string]any{}}
+	if err := ToStruct(in, &out, Options{Reset: true}); err != nil {
+		t.Fatal(err)
+	}
+	if &out.Items[0] != &items[0] || len(out.Items) != 1 {
+		t.Errorf("got %v, expected the slice's backing array to be reused", out.Items)
+	}
+	if items[1].X != 2 || items[2].X != 3 {
+		t.Errorf("got %v, expected the elements past the new length to be left alone", items)
+	}
+	if reflect.ValueOf(out.Labels).UnsafePointer() != reflect.ValueOf(labels).UnsafePointer() || len(labels) != 1 {
+		t.Errorf("got %v, expected the map to be reused", out.Labels)
+	}
+	if out.Ptr != ptr || !reflect.DeepEqual(*ptr, resetInner{}) {
+		t.Errorf("got %+v, expected the pointer to be reused", out.Ptr)
+	}
+}
+
+func BenchmarkToStructReset(b *testing.B) {
+	in := map[string]any{"name": "a", "items": []any{map[string]any{"x": 1}, map[string]any{"x": 2}}, "labels": map[string]any{"a": 1}}
+	var out resetTarget
+	for i := 0; i < b.N; i++ {
+		_ = ToStruct(in, &out, Options{Reset: true})
+	}
+}
+
+type cloneNode struct {
+	Name     string         `json:"name"`
+	Tags     []string       `json:"tags"`
+	Scores   map[string]int `json:"scores,omitempty"`
+	Children []*cloneNode   `json:"children,omitempty"`
+	Next     *cloneNode     `json:"next,omitempty"`
+	Points   []genItem      `json:"points"`
+	When     time.Time      `json:"when"`
+	Any      any            `json:"any"`
+	Hidden   string         `json:"-"`
+	big      *big.Int
+	internal map[string]int
+}
+
+func TestClone(t *testing.T) {
+	shared := &cloneNode{Name: "shared", Tags: []string{"s"}}
+	in := cloneNode{
+		Name:     "root",
+		Tags:     []string{"a", "b"},
+		Scores:   map[string]int{"x": 1},
+		Children: []*cloneNode{shared, shared, nil},
+		Points:   []genItem{{SKU: "p", Qty: 1, Price: 2.5}},
+		When:     time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Any:      &genItem{SKU: "any"},
+		Hidden:   "hidden",
+		big:      big.NewInt(5),
+		internal: map[string]int{"i": 1},
+	}
+	for _, sem := range []Semantics{SemanticsV1, SemanticsV2} {
+		if sem == SemanticsV2 && !jsonv2Available {
+			continue
+		}
+		got, err := CloneOpts(in, CloneOptions{Options: Options{Semantics: sem}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := ConvertTo[cloneNode](in, Options{Semantics: sem})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ConvertTo turns what the interface holds into a map
+		want.Any = &genItem{SKU: "any"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Got %+v\nExpected %+v with %v", got, want, sem)
+		}
+	}
+
+	got := Clone(in)
+	if got.Hidden != "" || got.big != nil || got.internal != nil {
+		t.Errorf("Clone copied fields JSON doesn't see: %+v", got)
+	}
+	if got.Children[0] == got.Children[1] {
+		t.Errorf("Clone preserved aliasing without being asked to")
+	}
+	assertNotShared(t, got, in)
+	if got.Any.(*genItem) == in.Any.(*genItem) {
+		t.Errorf("Clone shared the pointer in an interface")
+	}
+}
+
+func TestCloneAllFields(t *testing.T) {
+	in := cloneNode{
+		Name:     "root",
+		Tags:     []string{"a"},
+		Hidden:   "hidden",
+		When:     time.Now(),
+		big:      big.NewInt(5),
+		internal: map[string]int{"i": 1},
+	}
+	got, err := CloneOpts(in, CloneOptions{AllFields: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("Got %+v\nExpected %+v", got, in)
+	}
+	if got.big == in.big || got.When.Location() != in.When.Location() {
+		t.Errorf("Expected a new big.Int and the same time.Location")
+	}
+	got.big.SetInt64(6)
+	got.internal["i"] = 2
+	if in.big.Int64() != 5 || in.internal["i"] != 1 {
+		t.Errorf("Changing the clone changed the original: %+v", in)
+	}
+	assertNotShared(t, got, in)
+}
+
+func TestClonePreserveAliasing(t *testing.T) {
+	shared := &cloneNode{Name: "shared"}
+	in := &cloneNode{Name: "root", Children: []*cloneNode{shared, shared}}
+	in.Next = in
+	in.Tags = []string{"a", "b"}
+	in.Any = in.Tags
+
+	if _, err := CloneOpts(in, CloneOptions{}); err == nil {
+		t.Errorf("Expected an error for a cycle")
+	} else if valErr := (*json.UnsupportedValueError)(nil); !errors.As(err, &valErr) {
+		t.Errorf("Expected an UnsupportedValueError for a cycle, got %v", err)
+	}
+
+	got, err := CloneOpts(in, CloneOptions{PreserveAliasing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == in || got.Next != got {
+		t.Errorf("Expected the copy to point to itself")
+	}
+	if got.Children[0] != got.Children[1] || got.Children[0] == shared {
+		t.Errorf("Expected both children to be the same copy")
+	}
+	if tags := got.Any.([]string); &tags[0] != &got.Tags[0] || &tags[0] == &in.Tags[0] {
+		t.Errorf("Expected the same slice to be copied once")
+	}
+}
+
+func TestClonePreserveAliasingSubslices(t *testing.T) {
+	type slices struct {
+		All, Tail, Middle, Empty []int
+		Nodes, MoreNodes         []*cloneNode
+	}
+	arr := []int{1, 2, 3, 4, 5, 6}
+	nodes := []*cloneNode{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	in := slices{All: arr[:4], Tail: arr[2:], Middle: arr[1:3], Empty: arr[6:], Nodes: nodes[:2], MoreNodes: nodes[1:]}
+	got, err := CloneOpts(in, CloneOptions{PreserveAliasing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("Got %+v\nExpected %+v", got, in)
+	}
+	if &got.Tail[0] != &got.All[2] || &got.Middle[0] != &got.All[1] || &got.All[0] == &arr[0] {
+		t.Errorf("Expected the slices to share a new backing array")
+	}
+	if got.All = got.All[:cap(got.All)]; got.All[5] != 6 {
+		t.Errorf("Got %v, expected the copy to have the elements Tail goes on to", got.All)
+	}
+	if &got.MoreNodes[0] != &got.Nodes[1] || got.MoreNodes[0] == nodes[1] {
+		t.Errorf("Expected the slices of nodes to share a new backing array")
+	}
+}
+
+func TestCloneErrors(t *testing.T) {
+	type unencodable struct {
+		C chan int
+		P unsafe.Pointer
+		X complex128
+		Y []complex64
+	}
+	var x int
+	in := unencodable{C: make(chan int), P: unsafe.Pointer(&x), X: 1 + 2i, Y: []complex64{3 + 4i}}
+	// json.Marshal rejects all of these, but Clone copies them as they are
+	for _, allFields := range []bool{false, true} {
+		got, err := CloneOpts(in, CloneOptions{AllFields: allFields})
+		if err != nil || got.C != in.C || got.P != in.P || !reflect.DeepEqual(got, in) {
+			t.Errorf("Expected AllFields %v to share channels and unsafe pointers and copy complex numbers, got %+v, %v", allFields, got, err)
+		}
+		if &got.Y[0] == &in.Y[0] {
+			t.Errorf("Expected AllFields %v to copy the slice", allFields)
+		}
+	}
+	if got := Clone(in); !reflect.DeepEqual(got, in) {
+		t.Errorf("Got %+v, expected %+v", got, in)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Clone to panic on a cycle")
+		}
+	}()
+	m := map[string]any{}
+	m["m"] = m
+	Clone(m)
+}
+
+// assertNotShared fails if got and in, copies of each other, share any slices or maps.
+func assertNotShared(t *testing.T, got, in any) {
+	t.Helper()
+	seen := map[unsafe.Pointer]bool{}
+	var walk func(v reflect.Value, record bool)
+	walk = func(v reflect.Value, record bool) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			// Locations are meant to be shared
+			if !v.IsNil() && v.Type() != locationPtrType {
+				walk(v.Elem(), record)
+			}
+		case reflect.Slice, reflect.Map:
+			if v.IsNil() || v.Len() == 0 {
+				return
+			}
+			if record {
+				seen[v.UnsafePointer()] = true
+			} else if seen[v.UnsafePointer()] {
+				t.Errorf("The clone shares a %v with the original", v.Type())
+			}
+			if v.Kind() == reflect.Slice {
+				for i := 0; i < v.Len(); i++ {
+					walk(v.Index(i), record)
+				}
+			} else {
+				for iter := v.MapRange(); iter.Next(); {
+					walk(iter.Value(), record)
+				}
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i), record)
+			}
+		}
+	}
+	walk(reflect.ValueOf(in), true)
+	walk(reflect.ValueOf(got), false)
+}
+
+func BenchmarkCloneConvertTo(b *testing.B) {
+	in := benchmarkCloneInput()
+	for i := 0; i < b.N; i++ {
+		_, _ = ConvertTo[cloneNode](in)
+	}
+}
+
+func BenchmarkClone(b *testing.B) {
+	in := benchmarkCloneInput()
+	for i := 0; i < b.N; i++ {
+		_ = Clone(in)
+	}
+}
+
+func benchmarkCloneInput() cloneNode {
+	in := cloneNode{Name: "root", Tags: []string{"a", "b", "c"}, Scores: map[string]int{"x": 1, "y": 2}}
+	for i := 0; i < 10; i++ {
+		in.Points = append(in.Points, genItem{SKU: strconv.Itoa(i), Qty: int32(i), Price: float64(i)})
+		in.Children = append(in.Children, &cloneNode{Name: strconv.Itoa(i), Tags: []string{"x"}})
+	}
+	return in
+}
+
+type diffModel struct {
+	ID      int64          `json:"id,string"`
+	Name    string         `json:"name"`
+	Score   float32        `json:"score"`
+	Tags    []string       `json:"tags,omitempty"`
+	Address *patchAddress  `json:"address,omitempty"`
+	Labels  map[int]string `json:"labels"`
+	When    time.Time      `json:"when"`
+	secret  string
+}
+
+func TestEqual(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	model := diffModel{ID: 7, Name: "a", Score: 0.1, Address: &patchAddress{City: "x"}, Labels: map[int]string{1: "one"}, When: when, secret: "s"}
+	golden := map[string]any{
+		"id":      "7",
+		"name":    "a",
+		"score":   0.1,
+		"address": map[string]any{"city": "x"},
+		"labels":  map[string]any{"1": "one"},
+		"when":    "2020-01-02T03:04:05Z",
+	}
+	for i, tc := range []struct {
+		a, b any
+		want bool
+	}{
+		{model, golden, true},
+		{model, model, true},
+		{golden, model, true},
+		{1, 1.0, true},
+		{int64(math.MaxInt64), uint64(math.MaxInt64), true},
+		{int64(1 << 53), float64(1 << 53), true},
+		{int64(1<<53 + 1), float64(1 << 53), false},
+		{json.Number("1.50"), 1.5, true},
+		{json.Number("1e2"), 100, true},
+		{json.Number("1e100000000"), json.Number("1e100000001"), false},
+		{float32(0.1), 0.1, true},
+		{"1", 1, false},
+		{nil, map[string]any(nil), true},
+		{[]int{}, []any{}, true},
+		{[]int(nil), []any{}, false},
+		{[]byte("hi"), "aGk=", true},
+		{map[string]any{"a": 1}, map[string]any{"a": 1, "b": 2}, false},
+		{[]int{1, 2}, []float64{1, 2}, true},
+		{[]int{1, 2}, []float64{2, 1}, false},
+		{struct{ A int }{}, struct {
+			A int
+			B int `json:"-"`
+			C int `json:",omitempty"`
+		}{B: 1}, true},
+	} {
+		if got := Equal(tc.a, tc.b); got != tc.want {
+			t.Errorf("%d: Equal(%#v, %#v) = %v, expected %v", i, tc.a, tc.b, got, tc.want)
+		}
+		if changes, err := Diff(tc.a, tc.b); err != nil || (len(changes) == 0) != tc.want {
+			t.Errorf("%d: Diff(%#v, %#v) = %+v, %v, expected changes to be %v", i, tc.a, tc.b, changes, err, !tc.want)
+		}
+	}
+	if Equal(math.NaN(), math.NaN()) {
+		t.Errorf("Expected values that can't be marshaled not to be equal")
+	}
+
+	// the same field naming as conversion
+	type tagged struct {
+		UserID int `goloose:"uid"`
+	}
+	if !Equal(tagged{UserID: 1}, map[string]any{"uid": 1}, Options{TagNames: []string{"goloose"}}) {
+		t.Errorf("Expected Equal to use Options.TagNames")
+	}
+	if !Equal(tagged{UserID: 1}, map[string]any{"user_id": 1}, Options{TagNames: []string{"nope"}, NameMapper: SnakeCase}) {
+		t.Errorf("Expected Equal to use Options.NameMapper")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := diffModel{ID: 7, Name: "a", Tags: []string{"x", "y"}, Address: &patchAddress{City: "x", Zip: "1"}, Labels: map[int]string{1: "one"}}
+	b := map[string]any{
+		"id":      7,
+		"name":    "b",
+		"score":   0,
+		"tags":    []any{"x", "z", "w"},
+		"address": map[string]any{"city": "x"},
+		"labels":  nil,
+		"when":    "0001-01-01T00:00:00Z",
+		"extra":   true,
+	}
+	got, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Kind: ChangeRemoved, Path: "address.zip", From: "1"},
+		{Kind: ChangeAdded, Path: "extra", To: true},
+		{Kind: ChangeModified, Path: "id", From: "7", To: json.Number("7")},
+		{Kind: ChangeModified, Path: "labels", From: map[string]any{"1": "one"}, To: nil},
+		{Kind: ChangeModified, Path: "name", From: "a", To: "b"},
+		{Kind: ChangeModified, Path: "tags.1", From: "y", To: "z"},
+		{Kind: ChangeAdded, Path: "tags.2", To: "w"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v\nExpected %+v", got, want)
+	}
+	got, _ = Diff([]int{1, 2, 3}, []int{1})
+	want = []Change{
+		{Kind: ChangeRemoved, Path: "2", From: json.Number("3")},
+		{Kind: ChangeRemoved, Path: "1", From: json.Number("2")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v\nExpected %+v for a shorter array", got, want)
+	}
+	if got, _ := Diff(1, "1"); !reflect.DeepEqual(got, []Change{{Kind: ChangeModified, From: json.Number("1"), To: "1"}}) {
+		t.Errorf("Got %+v for different types", got)
+	}
+	// like CollectErrors, what can't be marshaled is left out
+	if got, _ := Diff(map[string]any{"a": math.NaN(), "b": 1}, map[string]any{"b": 2}); !reflect.DeepEqual(got, []Change{{Kind: ChangeModified, Path: "b", From: json.Number("1"), To: json.Number("2")}}) {
+		t.Errorf("Got %+v with a NaN", got)
+	}
+	for _, options := range [][]Options{{{MaxDepth: -1}}, {{}, {}}} {
+		if got, err := Diff(1, 2, options...); err == nil {
+			t.Errorf("Got %+v, expected an error for the options %+v", got, options)
+		}
+		if Equal(1, 1, options...) {
+			t.Errorf("Expected nothing to be equal with the options %+v", options)
+		}
+	}
+}
+
+func BenchmarkEqual(b *testing.B) {
+	model := diffModel{ID: 7, Name: "a", Tags: []string{"x", "y"}, Address: &patchAddress{City: "x"}, Labels: map[int]string{1: "one"}}
+	golden := map[string]any{"id": "7", "name": "a", "score": 0, "tags": []any{"x", "y"}, "address": map[string]any{"city": "x"}, "labels": map[string]any{"1": "one"}, "when": "0001-01-01T00:00:00Z"}
+	for i := 0; i < b.N; i++ {
+		if !Equal(model, golden) {
+			b.Fatal("not equal")
+		}
+	}
+}
+
+func TestCreatePatch(t *testing.T) {
+	a := diffModel{ID: 7, Name: "a/b", Tags: []string{"x", "y", "z"}, Address: &patchAddress{City: "x", Zip: "1"}, Labels: map[int]string{1: "one"}}
+	b := diffModel{ID: 8, Name: "a/b", Tags: []string{"w"}, Labels: map[int]string{1: "one", 2: "two"}, When: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	got, err := CreatePatch(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PatchOp{
+		{Op: "remove", Path: "/address"},
+		{Op: "replace", Path: "/id", Value: "8"},
+		{Op: "add", Path: "/labels/2", Value: "two"},
+		{Op: "replace", Path: "/tags/0", Value: "w"},
+		{Op: "remove", Path: "/tags/2"},
+		{Op: "remove", Path: "/tags/1"},
+		{Op: "replace", Path: "/when", Value: "2020-01-02T03:04:05Z"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Got %+v\nExpected %+v", got, want)
+	}
+	if err := ApplyPatch(&a, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Applying the patch got %+v, expected %+v", a, b)
+	}
+
+	got, err = CreatePatch(map[string]any{"a~b": 1, "c": []any{}}, map[string]any{"a~b": 2, "c/d": nil, "c": []any{1.5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []PatchOp{
+		{Op: "replace", Path: "/a~0b", Value: json.Number("2")},
+		{Op: "add", Path: "/c/0", Value: json.Number("1.5")},
+		{Op: "add", Path: "/c~1d", Value: nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %+v\nExpected %+v", got, want)
+	}
+	if got, err := CreatePatch(1, "1"); err != nil || !reflect.DeepEqual(got, []PatchOp{{Op: "replace", Path: "", Value: "1"}}) {
+		t.Errorf("Got %+v, %v for different types", got, err)
+	}
+	if got, err := CreatePatch(a, a); err != nil || got == nil || len(got) != 0 {
+		t.Errorf("Got %#v, %v for no differences", got, err)
+	}
+	if _, err := CreatePatch(math.NaN(), 1); err == nil {
+		t.Error("Expected an error for a NaN")
+	}
+
+	b1, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"op":"replace","path":"/a~0b","value":2},{"op":"add","path":"/c/0","value":1.5},{"op":"add","path":"/c~1d","value":null}]`; string(b1) != expected {
+		t.Errorf("Got %s, expected %s", b1, expected)
+	}
+	b1, err = json.Marshal([]PatchOp{{Op: "remove", Path: "/a"}, {Op: "move", From: "", Path: "/b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"op":"remove","path":"/a"},{"op":"move","path":"/b","from":""}]`; string(b1) != expected {
+		t.Errorf("Got %s, expected %s", b1, expected)
+	}
+}
+
+// TestApplyPatchRFC runs the examples from RFC 6902's appendix A.
+func TestApplyPatchRFC(t *testing.T) {
+	for _, tc := range []struct {
+		name, doc, patch, want string
+	}{
+		{"add object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"remove object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{"test", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+		{"add nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{"ignore unrecognized elements", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"foo":"bar","baz":"qux"}`},
+		{"add array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{"escaped paths", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"copy","from":"/~1","path":"/a"}]`, `{"/":9,"~1":10,"a":9}`},
+		{"replace the whole document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`},
+		{"copy into itself", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/foo/baz"}]`, `{"foo":{"bar":1,"baz":{"bar":1}}}`},
+	} {
+		var doc, want any
+		var ops []PatchOp
+		if err := json.Unmarshal([]byte(tc.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.patch), &ops); err != nil {
+			t.Fatal(err)
+		}
+		if err := ApplyPatch(&doc, ops); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !reflect.DeepEqual(doc, want) {
+			t.Errorf("%s: got %v, expected %v", tc.name, doc, want)
+		}
+	}
+}
+
+type PatchOwner struct {
+	Owner string `json:"owner"`
+}
+
+type patchEmbedding struct {
+	*PatchOwner
+	Count int `json:"count,string"`
+}
+
+func TestApplyPatch(t *testing.T) {
+	target := patchTarget{
+		Name:    "Ann",
+		Tags:    []string{"a", "b"},
+		Labels:  map[string]string{"team": "x"},
+		Extra:   map[string]any{"k": []any{"v"}},
+		Updated: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	address := &patchAddress{City: "Paris"}
+	target.Address = address
+	ops := []PatchOp{
+		{Op: "test", Path: "/name", Value: "Ann"},
+		{Op: "replace", Path: "/age", Value: 41.0},
+		{Op: "add", Path: "/tags/0", Value: "z"},
+		{Op: "remove", Path: "/tags/2"},
+		{Op: "add", Path: "/address/zip", Value: "75001"},
+		{Op: "move", From: "/labels/team", Path: "/labels/group"},
+		{Op: "add", Path: "/meta/n", Value: json.Number("2")},
+		{Op: "add", Path: "/extra/k/-", Value: true},
+		{Op: "copy", From: "/address", Path: "/extra/home"},
+		{Op: "replace", Path: "/updated", Value: "2025-01-02T03:04:05Z"},
+		{Op: "add", Path: "/unknown", Value: 1},
+	}
+	if err := ApplyPatch(&target, ops); err != nil {
+		t.Fatal(err)
+	}
+	want := patchTarget{
+		Name:    "Ann",
+		Age:     41,
+		Tags:    []string{"z", "a"},
+		Address: &patchAddress{City: "Paris", Zip: "75001"},
+		Labels:  map[string]string{"group": "x"},
+		Meta:    map[string]any{"n": 2.0},
+		Extra:   map[string]any{"k": []any{"v", true}, "home": map[string]any{"city": "Paris", "zip": "75001"}},
+		Updated: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("Got %+v\nExpected %+v", target, want)
+	}
+	if target.Address != address {
+		t.Error("Expected the address to be patched in place")
+	}
+
+	// embedded struct pointers are allocated, and ",string" fields take the values they're marshaled as
+	var embedding patchEmbedding
+	if err := ApplyPatch(&embedding, []PatchOp{{Op: "add", Path: "/owner", Value: "me"}, {Op: "replace", Path: "/count", Value: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if embedding.PatchOwner == nil || embedding.Owner != "me" || embedding.Count != 3 {
+		t.Errorf("Got %+v", embedding)
+	}
+	if err := ApplyPatch(&embedding, []PatchOp{{Op: "test", Path: "/count", Value: "3"}, {Op: "remove", Path: "/owner"}}); err != nil {
+		t.Fatal(err)
+	}
+	if embedding.PatchOwner != nil {
+		// its only field is gone, so it's cleared to leave the field out of the JSON
+		t.Errorf("Got %+v after removing", embedding)
+	}
+
+	before := want
+	before.Tags = slices.Clone(want.Tags)
+	for _, tc := range []struct {
+		name string
+		ops  []PatchOp
+		want error
+	}{
+		{"failed test", []PatchOp{{Op: "add", Path: "/tags/-", Value: "c"}, {Op: "test", Path: "/age", Value: "41"}}, ErrTestFailed},
+		{"missing member", []PatchOp{{Op: "remove", Path: "/labels/team"}}, errNoValue},
+		{"replace missing member", []PatchOp{{Op: "replace", Path: "/nope", Value: 1}}, errNoValue},
+		{"index out of range", []PatchOp{{Op: "add", Path: "/tags/3", Value: "c"}}, errNoValue},
+		{"leading zero", []PatchOp{{Op: "replace", Path: "/tags/01", Value: "c"}}, errNoValue},
+		{"into a scalar", []PatchOp{{Op: "add", Path: "/name/x", Value: "c"}}, errNoValue},
+		{"into a time", []PatchOp{{Op: "add", Path: "/updated/x", Value: "c"}}, errNoValue},
+		{"wrong type", []PatchOp{{Op: "replace", Path: "/age", Value: "old"}}, nil},
+		{"move into itself", []PatchOp{{Op: "move", From: "/address", Path: "/address/city"}}, nil},
+		{"invalid pointer", []PatchOp{{Op: "remove", Path: "tags"}}, nil},
+		{"invalid escape", []PatchOp{{Op: "remove", Path: "/a~2"}}, nil},
+		{"unknown operation", []PatchOp{{Op: "delete", Path: "/name"}}, nil},
+	} {
+		err := ApplyPatch(&target, tc.ops)
+		if err == nil || tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, expected %v", tc.name, err, tc.want)
+		}
+		if !reflect.DeepEqual(target, before) {
+			t.Errorf("%s: the target changed to %+v", tc.name, target)
+		}
+	}
+	err := ApplyPatch(&target, []PatchOp{{Op: "add", Path: "/unknown", Value: 1}}, Options{DisallowUnknownFields: true})
+	if !errors.Is(err, ErrUnknownField) {
+		t.Errorf("Got %v, expected ErrUnknownField", err)
+	}
+	var arr [2]int
+	if err := ApplyPatch(&arr, []PatchOp{{Op: "replace", Path: "/1", Value: 5}}); err != nil || arr != [2]int{0, 5} {
+		t.Errorf("Got %v, %v", arr, err)
+	}
+	if err := ApplyPatch(&arr, []PatchOp{{Op: "add", Path: "/1", Value: 5}}); err == nil {
+		t.Error("Expected an error adding to an array")
+	}
+	if err := ApplyPatch(target, nil); err == nil {
+		t.Error("Expected an error for a non-pointer")
+	}
+}
+
+// patchCounted counts the times it's unmarshaled, in patchCountedCalls.
+// PatchAuthor and PatchReview are exported so that ApplyPatch can allocate them as embedded pointers.
+type PatchAuthor struct {
+	*PatchReview
+	Author string   `json:"author"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+type PatchReview struct {
+	Score int `json:"score"`
+}
+
+type patchDoc struct {
+	*PatchAuthor
+	Title string `json:"title"`
+}
+
+func TestPatchRoundTripEmbeddedPointers(t *testing.T) {
+	full := func() patchDoc {
+		return patchDoc{PatchAuthor: &PatchAuthor{PatchReview: &PatchReview{Score: 3}, Author: "a", Tags: []string{"x"}}, Title: "t"}
+	}
+	for i, tc := range []struct{ from, to patchDoc }{
+		{full(), patchDoc{Title: "u"}},
+		{patchDoc{Title: "u"}, full()},
+		{full(), patchDoc{PatchAuthor: &PatchAuthor{Author: "b"}}},
+		{patchDoc{PatchAuthor: &PatchAuthor{}}, patchDoc{}},
+		// the fields are still there when they aren't all removed
+		{full(), patchDoc{PatchAuthor: &PatchAuthor{PatchReview: &PatchReview{}}}},
+		{patchDoc{PatchAuthor: &PatchAuthor{}}, patchDoc{PatchAuthor: &PatchAuthor{}}},
+	} {
+		ops, err := CreatePatch(tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := tc.from
+		if err := ApplyPatch(&got, ops); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !Equal(got, tc.to) || !reflect.DeepEqual(got, tc.to) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tc.to)
+			t.Errorf("%d: applying %+v got %s, expected %s", i, ops, gotJSON, wantJSON)
+		}
+	}
+}
+
+type patchCounted int
+
+var patchCountedCalls int
+
+func (c *patchCounted) UnmarshalJSON(b []byte) error {
+	patchCountedCalls++
+	return json.Unmarshal(b, (*int)(c))
+}
+
+func TestApplyPatchRunsOnce(t *testing.T) {
+	var target struct {
+		N patchCounted `json:"n"`
+	}
+	patchCountedCalls = 0
+	if err := ApplyPatch(&target, []PatchOp{{Op: "replace", Path: "/n", Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if target.N != 3 || patchCountedCalls != 1 {
+		t.Errorf("Got %v after %d calls, expected 3 after 1", target.N, patchCountedCalls)
+	}
+}
+
+func TestApplyPatchUndo(t *testing.T) {
+	type inner struct {
+		City string `json:"city"`
+	}
+	type target struct {
+		Tags  []string          `json:"tags"`
+		Inner inner             `json:"inner"`
+		P     *inner            `json:"-"`
+		Meta  map[string]any    `json:"meta"`
+		Empty map[string]string `json:"empty"`
+		Ptrs  map[string]*inner `json:"ptrs"`
+	}
+	backing := []string{"a", "b", "c", ""}
+	v := target{Tags: backing[:2], Inner: inner{City: "x"}, Meta: map[string]any{"a": []any{1.0}}, Ptrs: map[string]*inner{"p": {City: "y"}}}
+	v.P = &v.Inner
+	ptr := v.Ptrs["p"]
+	ops := []PatchOp{
+		{Op: "add", Path: "/tags/0", Value: "z"},
+		{Op: "remove", Path: "/tags/1"},
+		{Op: "replace", Path: "/inner/city", Value: "w"},
+		{Op: "add", Path: "/meta/a/-", Value: 2.0},
+		{Op: "add", Path: "/meta/b", Value: true},
+		{Op: "add", Path: "/empty/k", Value: "v"},
+		{Op: "replace", Path: "/ptrs/p/city", Value: "w"},
+		{Op: "move", From: "/ptrs/p", Path: "/ptrs/q"},
+	}
+	// the last one fails, so everything the others did has to be undone
+	if err := ApplyPatch(&v, append(ops, PatchOp{Op: "test", Path: "/tags/0", Value: "a"})); !errors.Is(err, ErrTestFailed) {
+		t.Fatalf("Got %v, expected ErrTestFailed", err)
+	}
+	want := target{Tags: []string{"a", "b"}, Inner: inner{City: "x"}, Meta: map[string]any{"a": []any{1.0}}, Ptrs: map[string]*inner{"p": {City: "y"}}}
+	want.P = &v.Inner
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("Got %+v\nExpected %+v", v, want)
+	}
+	if !slices.Equal(backing, []string{"a", "b", "c", ""}) || &v.Tags[0] != &backing[0] {
+		t.Errorf("Got %q, expected the spare capacity to be put back", backing)
+	}
+	if v.Ptrs["p"] != ptr {
+		t.Error("Expected the same pointer to be put back")
+	}
+
+	if err := ApplyPatch(&v, ops); err != nil {
+		t.Fatal(err)
+	}
+	if v.P.City != "w" || v.Empty["k"] != "v" || ptr.City != "w" || v.Ptrs["q"].City != "w" {
+		t.Errorf("Got %+v, expected the pointers to point to what was patched", v)
+	}
+}
+
+var updateGenerated = flag.Bool("update", false, "rewrite goloose_gen_test.go with the output of Generate")
+
+type genBase struct {
+	ID      string `json:"id"`
+	Created int64  `json:"created,omitempty"`
+}
+
+type genFlags struct {
+	Rush, Gift bool
+}
+
+type genItem struct {
+	SKU   string
+	Qty   int32
+	Price float64
+}
+
+type genItemDTO struct {
+	Sku   string
+	Qty   int64
+	Price float64
+}
+
+type genOrder struct {
+	*genBase
+	Customer string `json:"customer"`
+	By       string
+	Total    float32
+	Paid     bool    `json:",omitempty"`
+	Count    int     `json:",string"`
+	Code     string  `json:",string"`
+	Label    string  `json:",string"`
+	Score    float64 `json:",string"`
+	Rate     *float64
+	First    *genItem
+	Items    []genItem
+	Lines    map[string]genItem
+	Tags     []string
+	IDs      []int16
+	Meta     map[string]any
+	Note     any
+	When     time.Time
+	Ref      textID
+	Flags    genFlags `json:",omitzero"`
+	Skipped  string   `json:"-"`
+	Extra    string
+}
+
+// GenAudit is exported so that generated code can allocate it as an embedded pointer.
+type GenAudit struct {
+	By string
+}
+
+type genOrderDTO struct {
+	genBase
+	*GenAudit
+	Created  *int64 `json:"created"`
+	Customer string
+	Total    float32
+	Paid     bool
+	Count    int64  `json:",string"`
+	Code     string `json:",string"`
+	Label    string
+	Score    string
+	Rate     **float64
+	First    *genItemDTO
+	Items    []genItemDTO
+	Lines    map[string]genItemDTO
+	Tags     []string
+	IDs      []int64
+	Meta     map[string]any
+	Note     any
+	When     time.Time
+	Ref      textID
+	Flags    genFlags
+}
+
+var generateTestConfig = GenerateConfig{
+	PackageName: "goloose",
+	PackagePath: "github.com/reillywatson/goloose",
+	Pairs: []GeneratePair{
+		{Name: "genOrderToDTO", In: reflect.TypeFor[genOrder](), Out: reflect.TypeFor[genOrderDTO]()},
+		{Name: "genItemToDTO", In: reflect.TypeFor[genItem](), Out: reflect.TypeFor[genItemDTO]()},
+		{Name: "genIDToString", In: reflect.TypeFor[textID](), Out: reflect.TypeFor[string]()},
+	},
+}
+
+func TestGeneratedCodeIsUpToDate(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Generate(&buf, generateTestConfig); err != nil {
+		t.Fatal(err)
+	}
+	if *updateGenerated {
+		if err := os.WriteFile("goloose_gen_test.go", buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	current, err := os.ReadFile("goloose_gen_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, buf.Bytes()) {
+		t.Errorf("goloose_gen_test.go is out of date, run go test -run TestGeneratedCodeIsUpToDate -update")
+	}
+}
+
+func TestGenerateOptions(t *testing.T) {
+	cfg := generateTestConfig
+	cfg.Options = Options{CollectErrors: true, Reset: true, MaxDepth: 50, TagNames: []string{"db", "json"}, NameMapper: SnakeCase}
+	var buf bytes.Buffer
+	if err := Generate(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`Options{CollectErrors: true, Reset: true, MaxDepth: 50, TagNames: []string{"db", "json"}, NameMapper: SnakeCase}`,
+		"*out = genOrderDTO{}",
+		// like ToStruct, Reset zeroes out for a nil input
+		"if in == nil {\n\t\treturn ToStruct(in, out, ",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("generated code doesn't have %s", want)
+		}
+	}
+
+	cfg.Options = Options{Transforms: []TransformFunc{func(i any) any { return i }}}
+	if err := Generate(&buf, cfg); err == nil {
+		t.Error("expected an error for Transforms")
+	}
+}
+
+func TestGeneratedCodeMatchesToStruct(t *testing.T) {
+	rate := 0.25
+	prefilled := func() genOrderDTO {
+		r := ptrTo(1.0)
+		return genOrderDTO{Created: ptrTo[int64](5), Customer: "old", Rate: &r, First: &genItemDTO{Sku: "old", Qty: 9},
+			Items: []genItemDTO{{Sku: "old"}, {Qty: 1}}, Lines: map[string]genItemDTO{"old": {Qty: 1}, "a": {Qty: 2}},
+			Tags: []string{"old"}, IDs: []int64{7, 7, 7},
+			Meta: map[string]any{"old": true}, GenAudit: &GenAudit{By: "old"}, Ref: "old"}
+	}
+	for i, in := range []genOrder{
+		{},
+		{
+			genBase:  &genBase{ID: "o-1", Created: 1700000000},
+			Customer: "Ada",
+			By:       "Grace",
+			Total:    12.5,
+			Paid:     true,
+			Count:    3,
+			Code:     "abc",
+			Label:    "<l>",
+			Score:    0.5,
+			Rate:     &rate,
+			First:    &genItem{SKU: "x", Qty: 2, Price: 1.5},
+			Items:    []genItem{{SKU: "y", Qty: -1, Price: 0.1}, {}},
+			Lines:    map[string]genItem{"a": {SKU: "l", Price: 2}, "b": {}},
+			Tags:     []string{"a", "b"},
+			IDs:      []int16{1, -2, math.MaxInt16},
+			Meta:     map[string]any{"k": []any{1, "2"}},
+			Note:     genItem{SKU: "z"},
+			When:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Ref:      "r1",
+			Flags:    genFlags{Gift: true},
+			Skipped:  "skipped",
+			Extra:    "extra",
+		},
+		{genBase: &genBase{}, Tags: []string{}, Items: []genItem{}, Note: (*int)(nil), Ref: "r2"},
+		{Total: float32(math.Inf(1)), Ref: "r3"},
+		{Items: []genItem{{SKU: "ok"}, {Price: math.NaN()}}, Lines: map[string]genItem{}, Ref: "r4"},
+		{Lines: map[string]genItem{"bad": {Price: math.Inf(-1)}}, Ref: "r5"},
+		{Ref: ""},
+	} {
+		for _, prefill := range []bool{false, true} {
+			var got, want genOrderDTO
+			if prefill {
+				got, want = prefilled(), prefilled()
+			}
+			gotErr := genOrderToDTO(&in, &got)
+			wantErr := ToStruct(&in, &want)
+			if (gotErr != nil) != (wantErr != nil) || gotErr != nil && gotErr.Error() != wantErr.Error() {
+				t.Errorf("%d: got error %v, expected %v", i, gotErr, wantErr)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%d: got %+v, expected %+v", i, got, want)
+			}
+			if prefill {
+				continue
+			}
+			var slow genOrderDTO
+			slowErr := toStructSlow(&in, &slow)
+			if (gotErr != nil) != (slowErr != nil) {
+				t.Errorf("%d: got error %v, json gave %v", i, gotErr, slowErr)
+			}
+			if gotErr == nil && !reflect.DeepEqual(got, slow) {
+				t.Errorf("%d: got %+v, json gave %+v", i, got, slow)
+			}
+		}
+	}
+
+	var s string
+	if err := genIDToString(ptrTo(textID("abc")), &s); err != nil || s != "id-abc" {
+		t.Errorf("got %q, %v", s, err)
+	}
+	var item genItemDTO
+	if err := genItemToDTO(nil, &item); err != nil || item != (genItemDTO{}) {
+		t.Errorf("got %+v, %v", item, err)
+	}
+}
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
+
+func BenchmarkGenerated(b *testing.B) {
+	in := genItem{SKU: "some sku", Qty: 3, Price: 1.5}
+	for i := 0; i < b.N; i++ {
+		var out genItemDTO
+		_ = genItemToDTO(&in, &out)
+	}
+}
+
+func BenchmarkGeneratedToStruct(b *testing.B) {
+	in := genItem{SKU: "some sku", Qty: 3, Price: 1.5}
+	for i := 0; i < b.N; i++ {
+		var out genItemDTO
+		_ = ToStruct(&in, &out)
+	}
+}
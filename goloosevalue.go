@@ -0,0 +1,57 @@
+package goloose
+
+import "reflect"
+
+// GolooseValuer is implemented by types that can hand goloose a cheaper value to convert
+// than their JSON encoding. goloose converts whatever GolooseValue returns in their place,
+// and prefers this to a MarshalJSON or MarshalText method.
+type GolooseValuer interface {
+	GolooseValue() any
+}
+
+// GolooseValueSetter is implemented by types that can set themselves from a plain value
+// rather than their JSON encoding, and goloose prefers this to an UnmarshalJSON or UnmarshalText method.
+// If the input is a GolooseValuer, v is what its GolooseValue method returned. Otherwise it's
+// what json.Unmarshal would decode the input into for an interface{}: nil, a bool, a float64
+// (or whatever Options.UseNumber and Options.PreserveIntegers choose), a string, an []any or a map[string]any.
+type GolooseValueSetter interface {
+	SetGolooseValue(v any) error
+}
+
+var golooseValuerType = reflect.TypeOf(new(GolooseValuer)).Elem()
+var golooseValueSetterType = reflect.TypeOf(new(GolooseValueSetter)).Elem()
+
+// convertGolooseValue converts in into out if either of them implements
+// GolooseValuer or GolooseValueSetter, reporting whether it did.
+func convertGolooseValue(in, out reflect.Value, options Options, rec recursion) (bool, error) {
+	valuer := methodsOf(in.Type())&golooseValuerMethod != 0 && !isNil(in)
+	if out.Kind() == reflect.Ptr {
+		if !valuer || !out.CanSet() {
+			return false, nil
+		}
+		// follow the pointer first, so that the GolooseValue result can reach a GolooseValueSetter
+		if out.IsNil() {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		return true, toStructImpl(in, out.Elem(), options, rec.next())
+	}
+	var setter GolooseValueSetter
+	if out.CanAddr() && methodsOf(reflect.PointerTo(out.Type()))&golooseValueSetterMethod != 0 {
+		setter = out.Addr().Interface().(GolooseValueSetter)
+	}
+	if valuer {
+		v := in.Interface().(GolooseValuer).GolooseValue()
+		if setter != nil {
+			return true, setter.SetGolooseValue(v)
+		}
+		return true, toStructImpl(reflect.ValueOf(v), out, options, rec.next())
+	}
+	if setter == nil {
+		return false, nil
+	}
+	var v any
+	if err := toStructImpl(in, reflect.ValueOf(&v).Elem(), options, rec.next()); err != nil {
+		return true, err
+	}
+	return true, setter.SetGolooseValue(v)
+}
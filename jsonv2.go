@@ -0,0 +1,39 @@
+//go:build goexperiment.jsonv2 && go1.27
+
+package goloose
+
+import (
+	jsonv2 "encoding/json/v2"
+	"reflect"
+)
+
+// jsonv2Available reports whether encoding/json/v2 is available for SemanticsV2.
+const jsonv2Available = true
+
+func marshalV2(v any) ([]byte, error) {
+	return jsonv2.Marshal(v)
+}
+
+func unmarshalV2(b []byte, v any) error {
+	return jsonv2.Unmarshal(b, v)
+}
+
+var jsonv2MarshalerToType = reflect.TypeOf(new(jsonv2.MarshalerTo)).Elem()
+var jsonv2UnmarshalerFromType = reflect.TypeOf(new(jsonv2.UnmarshalerFrom)).Elem()
+
+// implementsJSONv2Methods reports whether t has any of the methods only encoding/json/v2 calls.
+func implementsJSONv2Methods(t reflect.Type) bool {
+	return t.Implements(jsonv2MarshalerToType) || t.Implements(jsonv2UnmarshalerFromType)
+}
+
+// reference version to compare SemanticsV2 against
+func toStructSlowV2(in interface{}, out interface{}) error {
+	if in == nil {
+		return nil
+	}
+	tmp, err := jsonv2.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return jsonv2.Unmarshal(tmp, out)
+}
@@ -0,0 +1,24 @@
+//go:build !goexperiment.jsonv2 || !go1.27
+
+package goloose
+
+import "reflect"
+
+// jsonv2Available reports whether encoding/json/v2 is available for SemanticsV2.
+const jsonv2Available = false
+
+func marshalV2(v any) ([]byte, error) {
+	return nil, errJSONv2Unavailable
+}
+
+func unmarshalV2(b []byte, v any) error {
+	return errJSONv2Unavailable
+}
+
+func implementsJSONv2Methods(t reflect.Type) bool {
+	return false
+}
+
+func toStructSlowV2(in interface{}, out interface{}) error {
+	return errJSONv2Unavailable
+}
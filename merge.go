@@ -0,0 +1,260 @@
+package goloose
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// MergePatch applies patch to out as a JSON merge patch (RFC 7386), like the body of a PATCH request.
+// Objects in patch are merged into out recursively: keys that are null clear the field or delete the
+// map entry they name, keys that are absent leave things as they are, and anything else, including
+// arrays, replaces what's there. A patch that isn't an object replaces all of out.
+//
+// It returns the paths of the values it changed, dot-separated like PathError.Path, or "" for out
+// itself. Values the patch sets to what they already were aren't included.
+func MergePatch(patch, out any, options ...Options) ([]string, error) {
+	var opt Options
+	if len(options) > 1 {
+		return nil, fmt.Errorf("pass at most one Options struct")
+	} else if len(options) == 1 {
+		opt = options[0]
+	}
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+
+	outVal := reflect.ValueOf(out)
+	if outVal.Kind() != reflect.Ptr || outVal.IsNil() {
+		return nil, fmt.Errorf("non-pointer type %T passed to MergePatch", out)
+	}
+	m := mergePatch{options: opt}
+	err := m.merge(reflect.ValueOf(patch), outVal.Elem(), "", recursion{})
+	return m.changed, unwrapSkipValError(err)
+}
+
+type mergePatch struct {
+	options Options
+	changed []string
+}
+
+// merge applies patch to out, which is at path.
+func (m *mergePatch) merge(patch, out reflect.Value, path string, rec recursion) error {
+	if rec.level > m.options.maxDepth() {
+		return ErrMaxDepth
+	}
+	if rec.seen != nil {
+		key, err := rec.visit(patch, out.Type())
+		if err != nil {
+			return err
+		}
+		defer delete(rec.seen, key)
+	}
+
+	obj, err := m.object(patch, rec)
+	if err != nil {
+		return err
+	}
+	if obj == nil || !m.mergeable(out.Type()) {
+		return m.replace(patch, out, path, rec)
+	}
+
+	n := len(m.changed)
+	switch out.Kind() {
+	case reflect.Ptr:
+		created := out.IsNil()
+		if created {
+			out.Set(reflect.New(out.Type().Elem()))
+		}
+		err := m.merge(reflect.ValueOf(obj), out.Elem(), path, rec.next())
+		m.created(created, n, path)
+		return err
+	case reflect.Interface:
+		if out.NumMethod() != 0 {
+			break
+		}
+		if !out.IsNil() {
+			// like json.Unmarshal, merge into a non-nil pointer held by the interface, and into a map too
+			if elem := out.Elem(); (elem.Kind() == reflect.Map || elem.Kind() == reflect.Ptr) && !elem.IsNil() {
+				return m.merge(reflect.ValueOf(obj), elem, path, rec.next())
+			}
+		}
+		newMap := reflect.MakeMap(mapStringInterfaceType)
+		err := m.mergeObject(obj, newMap, path, rec)
+		out.Set(newMap)
+		m.created(true, n, path)
+		return err
+	case reflect.Map:
+		created := out.IsNil()
+		if created {
+			out.Set(reflect.MakeMap(out.Type()))
+		}
+		err := m.mergeObject(obj, out, path, rec)
+		m.created(created, n, path)
+		return err
+	case reflect.Struct:
+		return m.mergeObject(obj, out, path, rec)
+	}
+	return m.replace(patch, out, path, rec)
+}
+
+// object returns patch as a JSON object, or nil if it's something else.
+func (m *mergePatch) object(patch reflect.Value, rec recursion) (map[string]any, error) {
+	for patch.Kind() == reflect.Interface && !patch.IsNil() {
+		patch = patch.Elem()
+	}
+	if isNull(patch, m.options) {
+		return nil, nil
+	}
+	if obj, ok := reflect.TypeAssert[map[string]any](patch); ok {
+		return obj, nil
+	}
+	v := patch
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct && v.Kind() != reflect.Map {
+		return nil, nil
+	}
+	// let toStructImpl work out what this looks like in JSON
+	var jsonVal any
+	if err := toStructImpl(patch, reflect.ValueOf(&jsonVal).Elem(), m.options, rec.next()); err != nil {
+		return nil, err
+	}
+	obj, _ := jsonVal.(map[string]any)
+	return obj, nil
+}
+
+// mergeable reports whether an object can be merged into a value of type outType,
+// rather than replacing it, which is what happens when the type decodes itself.
+func (m *mergePatch) mergeable(outType reflect.Type) bool {
+	return !decodesItself(outType, m.options)
+}
+
+// decodesItself reports whether converting an object into a value of type t is up to t, or a converter,
+// so that the object's members can't be matched up with parts of t.
+func decodesItself(t reflect.Type, options Options) bool {
+	p := cachedPlan(mapStringInterfaceType, t, options)
+	if p.customJson || p.goloose {
+		return true
+	}
+	_, ok := findConverter(mapStringInterfaceType, t, options)
+	return ok
+}
+
+// mergeObject merges each member of obj into the map or struct out.
+func (m *mergePatch) mergeObject(obj map[string]any, out reflect.Value, path string, rec recursion) error {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	outType := out.Type()
+	var outFields structFields
+	var unknownFields []string
+	var savedErrs []error
+	for _, name := range names {
+		val := reflect.ValueOf(obj[name])
+		switch out.Kind() {
+		case reflect.Map:
+			key, err := mapKey(name, outType.Key(), m.options)
+			if err != nil {
+				if err := saveError(&savedErrs, m.options, err); err != nil {
+					return err
+				}
+				continue
+			}
+			existing := out.MapIndex(key)
+			if isNull(val, m.options) {
+				if existing.IsValid() {
+					out.SetMapIndex(key, reflect.Value{})
+					m.changed = append(m.changed, memberPath(path, name))
+				}
+				continue
+			}
+			elem := reflect.New(outType.Elem()).Elem()
+			if existing.IsValid() {
+				elem.Set(existing)
+			}
+			n := len(m.changed)
+			err = m.merge(val, elem, memberPath(path, name), rec.next())
+			var skipErr *skipValError
+			if errors.As(err, &skipErr) {
+				// like toStructImpl, leave the entry alone
+				m.changed = m.changed[:n]
+			} else {
+				out.SetMapIndex(key, elem)
+			}
+			if err := saveError(&savedErrs, m.options, addErrorContext(err, nil, name)); err != nil {
+				return err
+			}
+		case reflect.Struct:
+			if outFields.list == nil {
+				outFields = cachedTypeFields(outType, m.options)
+			}
+			field := outFields.lookup(name, m.options.Semantics == SemanticsV2 || m.options.CaseSensitive)
+			if field == nil {
+				if m.options.DisallowUnknownFields {
+					unknownFields = append(unknownFields, name)
+				}
+				continue
+			}
+			if _, ok := obj[field.name]; ok && field.name != name {
+				// like ToStruct, another key matches the field exactly, and that one wins
+				continue
+			}
+			if isNull(val, m.options) {
+				if fieldVal, ok := fieldByIndexNoAlloc(out, field.index); ok && !fieldVal.IsZero() {
+					fieldVal.Set(reflect.Zero(fieldVal.Type()))
+					m.changed = append(m.changed, memberPath(path, field.name))
+				}
+				continue
+			}
+			if field.quoted {
+				val = dequote(val)
+			}
+			err := m.merge(val, fieldByIndex(out, field.index, true), memberPath(path, field.name), rec.next())
+			if err := saveError(&savedErrs, m.options, addErrorContext(err, outType, field.name)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := saveError(&savedErrs, m.options, unknownFieldsError(unknownFields)); err != nil {
+		return err
+	}
+	return joinErrors(savedErrs)
+}
+
+// replace sets out to patch, recording path if that changes it.
+func (m *mergePatch) replace(patch, out reflect.Value, path string, rec recursion) error {
+	newVal := reflect.New(out.Type()).Elem()
+	if !isNull(patch, m.options) {
+		if err := toStructImpl(patch, newVal, m.options, rec.next()); err != nil {
+			return err
+		}
+	}
+	if reflect.DeepEqual(out.Interface(), newVal.Interface()) {
+		return nil
+	}
+	out.Set(newVal)
+	m.changed = append(m.changed, path)
+	return nil
+}
+
+// created records path if the value there had to be created, and merging into it
+// didn't change anything else since len(m.changed) was n, like for an empty object.
+func (m *mergePatch) created(created bool, n int, path string) {
+	if created && len(m.changed) == n {
+		m.changed = append(m.changed, path)
+	}
+}
+
+// memberPath returns the path of the member called name of the object at path.
+func memberPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,80 @@
+package goloose
+
+import (
+	"strings"
+	"unicode"
+)
+
+// A NameMapper names struct fields that don't get a name from their tag.
+// Fields are cached per NameMapper, so custom implementations should be comparable
+// (like a struct or a pointer); other ones work but recompute the fields of every struct.
+type NameMapper interface {
+	MapName(fieldName string) string
+}
+
+// The built-in NameMappers. They split field names into words at case changes,
+// keeping initialisms together, so UserID becomes user_id, user-id, userId and USER_ID.
+var (
+	SnakeCase          NameMapper = caseMapper{sep: "_"}
+	KebabCase          NameMapper = caseMapper{sep: "-"}
+	CamelCase          NameMapper = caseMapper{camel: true}
+	ScreamingSnakeCase NameMapper = caseMapper{sep: "_", upper: true}
+)
+
+type caseMapper struct {
+	sep   string
+	upper bool
+	camel bool
+}
+
+func (m caseMapper) MapName(fieldName string) string {
+	var sb strings.Builder
+	for i, word := range splitWords(fieldName) {
+		switch {
+		case m.upper:
+			word = strings.ToUpper(word)
+		case m.camel && i > 0:
+			r := []rune(strings.ToLower(word))
+			r[0] = unicode.ToUpper(r[0])
+			word = string(r)
+		default:
+			word = strings.ToLower(word)
+		}
+		if i > 0 {
+			sb.WriteString(m.sep)
+		}
+		sb.WriteString(word)
+	}
+	return sb.String()
+}
+
+// splitWords splits a Go identifier into words, breaking at underscores, before an upper case
+// letter that follows a lower case letter or digit, and before the last letter of an initialism
+// that's followed by a lower case letter, like the S in HTTPServer.
+func splitWords(name string) []string {
+	var words []string
+	r := []rune(name)
+	start := 0
+	for i := 0; i < len(r); i++ {
+		if r[i] == '_' {
+			if i > start {
+				words = append(words, string(r[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i == start || !unicode.IsUpper(r[i]) {
+			continue
+		}
+		prev := r[i-1]
+		if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+			unicode.IsUpper(prev) && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			words = append(words, string(r[start:i]))
+			start = i
+		}
+	}
+	if start < len(r) {
+		words = append(words, string(r[start:]))
+	}
+	return words
+}
@@ -0,0 +1,594 @@
+package goloose
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// A PatchOp is one operation of a JSON patch (RFC 6902).
+type PatchOp struct {
+	Op    string `json:"op"`    // "add", "remove", "replace", "move", "copy" or "test"
+	Path  string `json:"path"`  // a JSON Pointer (RFC 6901) to the value the operation applies to, like "/items/0/name"
+	From  string `json:"from"`  // for move and copy, a JSON Pointer to the value to move or copy
+	Value any    `json:"value"` // for add, replace and test
+}
+
+// MarshalJSON encodes op with only the members its Op uses, so that a null Value is kept where it's needed.
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	type patchOp struct {
+		Op    string  `json:"op"`
+		Path  string  `json:"path"`
+		From  *string `json:"from,omitempty"`
+		Value *any    `json:"value,omitempty"`
+	}
+	out := patchOp{Op: op.Op, Path: op.Path}
+	switch op.Op {
+	case "move", "copy":
+		out.From = &op.From
+	case "add", "replace", "test":
+		out.Value = &op.Value
+	}
+	return json.Marshal(out)
+}
+
+// ErrTestFailed is returned by ApplyPatch when a test operation finds a different value than the one it expects.
+var ErrTestFailed = errors.New("test failed")
+
+var errNoValue = errors.New("no value at path")
+
+// CreatePatch returns a JSON patch (RFC 6902) of add, remove and replace operations that turns from into to,
+// which can have different types, the way Diff finds the differences between them: paths are what they'd
+// be if both values had been marshaled, and values are converted into interface{}s the way ToStruct would,
+// with Options.UseNumber. The patch is never nil, so it marshals as a JSON array.
+func CreatePatch(from, to any, options ...Options) ([]PatchOp, error) {
+	opt, err := diffOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := jsonValue(from, opt)
+	if err != nil {
+		return nil, err
+	}
+	y, err := jsonValue(to, opt)
+	if err != nil {
+		return nil, err
+	}
+	ops := []PatchOp{}
+	diffJSON(nil, x, y, func(kind ChangeKind, path []string, _, to any) {
+		switch kind {
+		case ChangeAdded:
+			ops = append(ops, PatchOp{Op: "add", Path: jsonPointer(path), Value: to})
+		case ChangeRemoved:
+			ops = append(ops, PatchOp{Op: "remove", Path: jsonPointer(path)})
+		case ChangeModified:
+			ops = append(ops, PatchOp{Op: "replace", Path: jsonPointer(path), Value: to})
+		}
+	})
+	return ops, nil
+}
+
+// ApplyPatch applies ops, a JSON patch (RFC 6902), to the value target points to, without marshaling it:
+// paths are followed through struct fields, map entries and slice elements the way they'd be in the
+// marshaled value, allocating embedded struct pointers and nil maps as needed, and values are converted
+// into the types they're put into the way ToStruct would. Removing a struct field sets it to its zero value,
+// and sets the embedded struct pointers it's promoted through to nil once none of their fields are left.
+// Values of types that marshal or unmarshal themselves are patched as JSON and converted back.
+//
+// Like the RFC says, the patch is applied all or nothing: if any operation fails, what the earlier ones
+// changed is changed back, so target is left as it was. A test operation that fails returns an error
+// wrapping ErrTestFailed.
+func ApplyPatch(target any, ops []PatchOp, options ...Options) error {
+	var opt Options
+	if len(options) > 1 {
+		return fmt.Errorf("pass at most one Options struct")
+	} else if len(options) == 1 {
+		opt = options[0]
+	}
+	if err := opt.validate(); err != nil {
+		return err
+	}
+
+	outVal := reflect.ValueOf(target)
+	if outVal.Kind() != reflect.Ptr || outVal.IsNil() {
+		return fmt.Errorf("non-pointer type %T passed to ApplyPatch", target)
+	}
+	p := patcher{options: opt}
+	if err := p.applyAll(outVal.Elem(), ops); err != nil {
+		for i := len(p.undo) - 1; i >= 0; i-- {
+			p.undo[i]()
+		}
+		return err
+	}
+	return nil
+}
+
+type patcher struct {
+	options Options
+	undo    []func() // puts back what each change to the target so far replaced, to undo them in reverse order
+
+	// the struct fields that have been removed, which json.Marshal would still see if they're zero
+	// but aren't omitted, so that an embedded pointer can be cleared once none of its fields are left
+	removed map[removedField]bool
+}
+
+type removedField struct {
+	ptr unsafe.Pointer
+	typ reflect.Type
+}
+
+func fieldKey(v reflect.Value) removedField {
+	return removedField{v.Addr().UnsafePointer(), v.Type()}
+}
+
+// record saves the value at v, which is about to change, so that it can be put back.
+func (p *patcher) record(v reflect.Value) {
+	old := reflect.New(v.Type()).Elem()
+	old.Set(v)
+	p.undo = append(p.undo, func() { v.Set(old) })
+}
+
+// recordMapIndex saves the entry for key in the map m, which is about to change, so that it can be put back.
+func (p *patcher) recordMapIndex(m, key reflect.Value) {
+	// a missing entry gives the zero Value, which deletes it again
+	old := m.MapIndex(key)
+	p.undo = append(p.undo, func() { m.SetMapIndex(key, old) })
+}
+
+// applyAll applies ops to v in order.
+func (p *patcher) applyAll(v reflect.Value, ops []PatchOp) error {
+	for i, op := range ops {
+		if err := p.apply(v, op); err != nil {
+			return fmt.Errorf("goloose: patch operation %d (%s %q): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return nil
+}
+
+// apply applies op to v.
+func (p *patcher) apply(v reflect.Value, op PatchOp) error {
+	path, err := parseJSONPointer(op.Path)
+	if err != nil {
+		return err
+	}
+	switch op.Op {
+	case "add", "replace":
+		return p.set(v, path, reflect.ValueOf(op.Value), op.Op == "add")
+	case "remove":
+		return p.remove(v, path)
+	case "move", "copy":
+		from, err := parseJSONPointer(op.From)
+		if err != nil {
+			return err
+		}
+		if op.Op == "move" && len(from) < len(path) && slices.Equal(from, path[:len(from)]) {
+			return fmt.Errorf("can't move %q into itself", op.From)
+		}
+		val, err := p.get(v, from, p.options)
+		if err != nil {
+			return fmt.Errorf("from %q: %w", op.From, err)
+		}
+		if op.Op == "move" {
+			if err := p.remove(v, from); err != nil {
+				return err
+			}
+		}
+		return p.set(v, path, reflect.ValueOf(val), true)
+	case "test":
+		numberOptions := p.options
+		numberOptions.UseNumber = true
+		got, err := p.get(v, path, numberOptions)
+		if err != nil {
+			return err
+		}
+		want, err := jsonValue(op.Value, numberOptions)
+		if err != nil {
+			return err
+		}
+		if !jsonEqual(got, want) {
+			return ErrTestFailed
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// get returns the value at path in v, as ToStruct would convert it into an interface{} with options.
+func (p *patcher) get(v reflect.Value, path []string, options Options) (any, error) {
+	if len(path) == 0 {
+		return jsonValue(v.Addr().Interface(), options)
+	}
+	var out any
+	err := p.walk(v, path, false, func(parent reflect.Value, name string) error {
+		// converting the parent takes care of omitted and quoted fields
+		j, err := jsonValue(parent.Addr().Interface(), options)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		switch j := j.(type) {
+		case map[string]any:
+			out, ok = j[name]
+		case []any:
+			var i int
+			if i, ok = arrayIndex(name, len(j)); ok {
+				out = j[i]
+			}
+		}
+		if !ok {
+			return errNoValue
+		}
+		return nil
+	})
+	return out, err
+}
+
+// set converts val into the value at path in v, which must already be there unless add is set.
+// Adding to an array inserts an element.
+func (p *patcher) set(v reflect.Value, path []string, val reflect.Value, add bool) error {
+	if len(path) == 0 {
+		p.removed = nil
+		return p.replace(v, val)
+	}
+	return p.walk(v, path, true, func(parent reflect.Value, name string) error {
+		switch parent.Kind() {
+		case reflect.Map:
+			key, err := mapKey(name, parent.Type().Key(), p.options)
+			if err != nil {
+				return err
+			}
+			if !add && !parent.MapIndex(key).IsValid() {
+				return errNoValue
+			}
+			elem := reflect.New(parent.Type().Elem()).Elem()
+			if err := p.replace(elem, val); err != nil {
+				return err
+			}
+			if parent.IsNil() {
+				p.record(parent)
+				parent.Set(reflect.MakeMap(parent.Type()))
+			}
+			p.recordMapIndex(parent, key)
+			parent.SetMapIndex(key, elem)
+			return nil
+		case reflect.Struct:
+			field := cachedTypeFields(parent.Type(), p.options).lookup(name, true)
+			if field == nil {
+				if !add {
+					return errNoValue
+				}
+				if p.options.DisallowUnknownFields {
+					return ErrUnknownField
+				}
+				// like ToStruct, ignore it
+				return nil
+			}
+			if _, ok := fieldByIndexNoAlloc(parent, field.index); !ok && !add {
+				return errNoValue
+			}
+			if field.quoted {
+				val = dequote(val)
+			}
+			fieldVal := p.field(parent, field.index)
+			delete(p.removed, fieldKey(fieldVal))
+			return p.replace(fieldVal, val)
+		case reflect.Slice, reflect.Array:
+			if isBinary(parent.Type(), p.options) {
+				break
+			}
+			n := parent.Len()
+			if !add {
+				i, ok := arrayIndex(name, n)
+				if !ok {
+					return errNoValue
+				}
+				return p.replace(parent.Index(i), val)
+			}
+			if parent.Kind() == reflect.Array {
+				return fmt.Errorf("can't add an element to %v, which has a fixed length", parent.Type())
+			}
+			i, ok := n, name == "-"
+			if !ok {
+				i, ok = arrayIndex(name, n+1)
+			}
+			if !ok {
+				return errNoValue
+			}
+			elem := reflect.New(parent.Type().Elem()).Elem()
+			if err := p.replace(elem, val); err != nil {
+				return err
+			}
+			if parent.Cap() > n {
+				// the elements from i on move up into the spare capacity
+				grown := parent.Slice(0, n+1)
+				for j := i; j <= n; j++ {
+					p.record(grown.Index(j))
+				}
+			}
+			s := reflect.Append(parent, elem)
+			reflect.Copy(s.Slice(i+1, n+1), s.Slice(i, n))
+			s.Index(i).Set(elem)
+			p.record(parent)
+			parent.Set(s)
+			return nil
+		}
+		return errNoValue
+	})
+}
+
+// remove removes the value at path in v.
+func (p *patcher) remove(v reflect.Value, path []string) error {
+	if len(path) == 0 {
+		p.record(v)
+		v.Set(reflect.Zero(v.Type()))
+		p.removed = nil
+		return nil
+	}
+	return p.walk(v, path, true, func(parent reflect.Value, name string) error {
+		switch parent.Kind() {
+		case reflect.Map:
+			key, err := mapKey(name, parent.Type().Key(), p.options)
+			if err != nil || !parent.MapIndex(key).IsValid() {
+				return errNoValue
+			}
+			p.recordMapIndex(parent, key)
+			parent.SetMapIndex(key, reflect.Value{})
+			return nil
+		case reflect.Struct:
+			field := cachedTypeFields(parent.Type(), p.options).lookup(name, true)
+			if field == nil {
+				return errNoValue
+			}
+			fieldVal, ok := fieldByIndexNoAlloc(parent, field.index)
+			if !ok {
+				return errNoValue
+			}
+			p.record(fieldVal)
+			fieldVal.Set(reflect.Zero(fieldVal.Type()))
+			if p.removed == nil {
+				p.removed = map[removedField]bool{}
+			}
+			p.removed[fieldKey(fieldVal)] = true
+			p.clearEmbedded(parent, field.index)
+			return nil
+		case reflect.Slice, reflect.Array:
+			if isBinary(parent.Type(), p.options) {
+				break
+			}
+			n := parent.Len()
+			i, ok := arrayIndex(name, n)
+			if !ok {
+				return errNoValue
+			}
+			if parent.Kind() == reflect.Array {
+				return fmt.Errorf("can't remove an element from %v, which has a fixed length", parent.Type())
+			}
+			for j := i; j < n; j++ {
+				p.record(parent.Index(j))
+			}
+			reflect.Copy(parent.Slice(i, n), parent.Slice(i+1, n))
+			parent.Index(n - 1).Set(reflect.Zero(parent.Type().Elem()))
+			p.record(parent)
+			parent.Set(parent.Slice(0, n-1))
+			return nil
+		}
+		return errNoValue
+	})
+}
+
+// clearEmbedded sets the embedded struct pointers on the way to the field of the struct v at index
+// to nil once none of the fields promoted through them are left, so that the field's removal
+// is seen when v is marshaled, even if the field isn't omitted when it's empty.
+func (p *patcher) clearEmbedded(v reflect.Value, index []int) {
+	fields := cachedTypeFields(v.Type(), p.options).list
+	for n := len(index) - 1; n > 0; n-- {
+		ptr, ok := fieldByIndexNoAlloc(v, index[:n])
+		if !ok || ptr.Kind() != reflect.Ptr {
+			continue
+		}
+		for i := range fields {
+			field := &fields[i]
+			if !slices.Equal(field.index[:min(n, len(field.index))], index[:n]) {
+				continue
+			}
+			val, ok := fieldByIndexNoAlloc(v, field.index)
+			if ok && !p.removed[fieldKey(val)] && !p.omitted(field, val) {
+				return
+			}
+		}
+		p.record(ptr)
+		ptr.Set(reflect.Zero(ptr.Type()))
+	}
+}
+
+// omitted reports whether json.Marshal leaves out field, whose value is val.
+func (p *patcher) omitted(field *field, val reflect.Value) bool {
+	if field.omitEmpty && (p.options.Semantics != SemanticsV2 && isEmptyValue(val) ||
+		p.options.Semantics == SemanticsV2 && isEmptyValueV2(val, p.options)) {
+		return true
+	}
+	return field.omitZero && (field.isZero == nil && val.IsZero() || field.isZero != nil && field.isZero(val))
+}
+
+// replace sets v to val converted into a zero value of v's type.
+func (p *patcher) replace(v, val reflect.Value) error {
+	newVal := reflect.New(v.Type()).Elem()
+	if !isNull(val, p.options) {
+		if err := toStructImpl(val, newVal, p.options, recursion{}); err != nil {
+			return unwrapSkipValError(err)
+		}
+	}
+	p.record(v)
+	v.Set(newVal)
+	return nil
+}
+
+// field returns the field of the struct v at index, allocating the embedded struct pointers on the way
+// like fieldByIndex does.
+func (p *patcher) field(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				p.record(v)
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+// walk follows path from v, except for its last name, and calls fn with the value it gets to and that name.
+// v has to be settable. If write is set, values that had to be copied to get there, like map entries,
+// are put back afterwards, so that fn can change them.
+func (p *patcher) walk(v reflect.Value, path []string, write bool, fn func(parent reflect.Value, name string) error) error {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return errNoValue
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return errNoValue
+		}
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		err := p.walk(elem, path, write, fn)
+		if write && err == nil {
+			p.record(v)
+			v.Set(elem)
+		}
+		return err
+	}
+	if p.opaque(v.Type()) {
+		j, err := jsonValue(v.Addr().Interface(), p.options)
+		if err != nil {
+			return err
+		}
+		if err := p.walk(reflect.ValueOf(&j).Elem(), path, write, fn); err != nil || !write {
+			return err
+		}
+		newVal := reflect.New(v.Type())
+		if err := ToStruct(j, newVal.Interface(), p.options); err != nil {
+			return err
+		}
+		p.record(v)
+		v.Set(newVal.Elem())
+		return nil
+	}
+	if len(path) == 1 {
+		return fn(v, path[0])
+	}
+
+	name, rest := path[0], path[1:]
+	switch v.Kind() {
+	case reflect.Map:
+		key, err := mapKey(name, v.Type().Key(), p.options)
+		if err != nil {
+			return errNoValue
+		}
+		existing := v.MapIndex(key)
+		if !existing.IsValid() {
+			return errNoValue
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		elem.Set(existing)
+		err = p.walk(elem, rest, write, fn)
+		if write && err == nil {
+			p.recordMapIndex(v, key)
+			v.SetMapIndex(key, elem)
+		}
+		return err
+	case reflect.Struct:
+		field := cachedTypeFields(v.Type(), p.options).lookup(name, true)
+		if field == nil {
+			return errNoValue
+		}
+		fieldVal, ok := fieldByIndexNoAlloc(v, field.index)
+		if !ok || field.quoted {
+			return errNoValue
+		}
+		return p.walk(fieldVal, rest, write, fn)
+	case reflect.Slice, reflect.Array:
+		if isBinary(v.Type(), p.options) {
+			break
+		}
+		i, ok := arrayIndex(name, v.Len())
+		if !ok {
+			return errNoValue
+		}
+		return p.walk(v.Index(i), rest, write, fn)
+	}
+	return errNoValue
+}
+
+// opaque reports whether values of type t don't marshal or unmarshal field by field, so that
+// paths into them have to be followed in their JSON instead.
+func (p *patcher) opaque(t reflect.Type) bool {
+	if decodesItself(t, p.options) {
+		return true
+	}
+	encode := cachedPlan(t, mapStringInterfaceType, p.options)
+	return encode.customJson || encode.goloose || encode.converter
+}
+
+// arrayIndex parses name as an index of an array of length n, the way a JSON Pointer writes it.
+func arrayIndex(name string, n int) (int, bool) {
+	if name == "" || len(name) > 1 && name[0] == '0' {
+		return 0, false
+	}
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	i, err := strconv.Atoi(name)
+	return i, err == nil && i < n
+}
+
+var (
+	jsonPointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	jsonPointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// jsonPointer returns the JSON Pointer for path.
+func jsonPointer(path []string) string {
+	var b strings.Builder
+	for _, name := range path {
+		b.WriteByte('/')
+		b.WriteString(jsonPointerEscaper.Replace(name))
+	}
+	return b.String()
+}
+
+// parseJSONPointer splits the JSON Pointer s into the names it's made of.
+func parseJSONPointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON Pointer %q", s)
+	}
+	path := strings.Split(s[1:], "/")
+	for i, name := range path {
+		for j := 0; j < len(name); j++ {
+			if name[j] != '~' {
+				continue
+			}
+			if j+1 == len(name) || name[j+1] != '0' && name[j+1] != '1' {
+				return nil, fmt.Errorf("invalid JSON Pointer %q", s)
+			}
+			j++
+		}
+		path[i] = jsonPointerUnescaper.Replace(name)
+	}
+	return path, nil
+}
@@ -0,0 +1,138 @@
+package goloose
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A plan records the decisions toStructImpl can make from the input and output types alone,
+// so that converting between the same types again doesn't have to repeat them.
+// Options.Transforms run before a plan is chosen, since they can change the input's type.
+type plan struct {
+	converter  bool        // a converter registered with RegisterConverter might apply
+	goloose    bool        // convertGolooseValue applies
+	fastPath   bool        // fastPathMapStringAny might apply
+	customJson bool        // customJson applies, if the output is addressable
+	fields     []fieldPlan // for a struct, each of its fields and where it goes if the output is a struct
+}
+
+// A fieldPlan pairs an input struct field with the output struct field it's converted into.
+type fieldPlan struct {
+	in       *field
+	out      *field // nil if the output isn't a struct, or has no field matching in
+	shadowed bool   // out is nil because in only matches an output field case-insensitively, and another input field matches it exactly
+}
+
+type planKey struct {
+	in, out       reflect.Type
+	tagNames      string // like fieldCacheKey
+	nameMapper    NameMapper
+	caseSensitive bool
+	semantics     Semantics
+}
+
+var planCache struct {
+	value atomic.Value // map[planKey]*plan
+	mu    sync.Mutex   // used only by writers
+}
+
+// cachedPlan returns the plan for converting inType into outType with options, making it if needed.
+func cachedPlan(inType, outType reflect.Type, options Options) *plan {
+	if options.NameMapper != nil && !reflect.TypeOf(options.NameMapper).Comparable() {
+		// this can't be a map key
+		return newPlan(inType, outType, options)
+	}
+	key := planKey{
+		in:            inType,
+		out:           outType,
+		tagNames:      strings.Join(options.TagNames, " "),
+		nameMapper:    options.NameMapper,
+		caseSensitive: options.Semantics == SemanticsV2 || options.CaseSensitive,
+		semantics:     options.Semantics,
+	}
+	m, _ := planCache.value.Load().(map[planKey]*plan)
+	if p, ok := m[key]; ok {
+		return p
+	}
+
+	// Like cachedTypeFields, make the plan without the lock.
+	p := newPlan(inType, outType, options)
+
+	planCache.mu.Lock()
+	m, _ = planCache.value.Load().(map[planKey]*plan)
+	newM := make(map[planKey]*plan, len(m)+1)
+	for k, v := range m {
+		newM[k] = v
+	}
+	newM[key] = p
+	planCache.value.Store(newM)
+	planCache.mu.Unlock()
+	return p
+}
+
+// planGeneration counts the times resetPlans has run, so that plans kept outside planCache can tell they're out of date.
+var planGeneration atomic.Uint64
+
+// resetPlans forgets every plan, for when the decisions they hold may have changed.
+func resetPlans() {
+	planCache.mu.Lock()
+	planCache.value.Store(map[planKey]*plan{})
+	planCache.mu.Unlock()
+	planGeneration.Add(1)
+	// so do clonePlans, which are made from them
+	clonePlanCache.mu.Lock()
+	clonePlanCache.value.Store(map[clonePlanKey]*clonePlan{})
+	clonePlanCache.mu.Unlock()
+}
+
+func newPlan(inType, outType reflect.Type, options Options) *plan {
+	p := &plan{}
+
+	// convertWithConverter follows pointers, and interfaces could hold anything
+	converters, _ := globalConverters.value.Load().(map[converterKey]TypeConverter)
+	for t := inType; len(converters) > 0; t = t.Elem() {
+		if _, ok := converters[converterKey{t, outType}]; ok || t.Kind() == reflect.Interface {
+			p.converter = true
+			break
+		}
+		if t.Kind() != reflect.Ptr {
+			break
+		}
+	}
+
+	inMethods := methodsOf(inType)
+	p.goloose = inMethods&golooseValuerMethod != 0 ||
+		outType.Kind() != reflect.Ptr && methodsOf(reflect.PointerTo(outType))&golooseValueSetterMethod != 0
+
+	switch inType {
+	case mapStringStringType, mapStringFloat64Type, mapStringIntType:
+		p.fastPath = outType == interfacePtrType || outType == mapStringInterfacePtrType
+	}
+
+	v2 := options.Semantics == SemanticsV2 && (needsJSONv2(inType, options) || needsJSONv2(outType, options))
+	outMethods := methodsOf(reflect.PointerTo(outType))
+	p.customJson = v2 || inMethods&(jsonMarshalerMethod|textMarshalerMethod) != 0 ||
+		outMethods&(jsonUnmarshalerMethod|textUnmarshalerMethod) != 0
+
+	if inType.Kind() == reflect.Struct {
+		caseSensitive := options.Semantics == SemanticsV2 || options.CaseSensitive
+		inFields := cachedTypeFields(inType, options)
+		var outFields structFields
+		if outType.Kind() == reflect.Struct {
+			outFields = cachedTypeFields(outType, options)
+		}
+		p.fields = make([]fieldPlan, len(inFields.list))
+		for i := range inFields.list {
+			out := outFields.lookup(inFields.list[i].name, caseSensitive)
+			shadowed := out != nil && out.name != inFields.list[i].name && inFields.lookup(out.name, true) != nil
+			if shadowed {
+				// another input field matches it exactly, and that one wins
+				out = nil
+			}
+			p.fields[i] = fieldPlan{in: &inFields.list[i], out: out, shadowed: shadowed}
+		}
+	}
+	return p
+}
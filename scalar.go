@@ -0,0 +1,339 @@
+package goloose
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tryToConvert sets out from the scalar value in, following the rules json.Unmarshal
+// applies to the JSON that json.Marshal produces for in: numbers must fit in the output
+// type without overflow or truncation, and mismatched kinds are UnmarshalTypeErrors.
+func tryToConvert(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type, options Options) error {
+	switch in.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		return &skipValError{err: &PathError{Err: &json.UnsupportedTypeError{Type: inType}}}
+	case reflect.Float32, reflect.Float64:
+		if f := in.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return unsupportedFloatError(in)
+		}
+	}
+	if inType == numberType {
+		// a json.Number is marshaled as the number it holds
+		if !isValidNumber(numberLiteral(in, inType)) {
+			return &skipValError{err: &PathError{Err: fmt.Errorf("json: invalid number literal %q", in.String())}}
+		}
+		if outType == numberType {
+			out.Set(in)
+			return nil
+		}
+		return numberLiteralToScalar(numberLiteral(in, inType), out, outType)
+	}
+	if in.Kind() == reflect.String && options.Semantics == SemanticsV2 && !utf8.ValidString(in.String()) {
+		// v1 replaces invalid UTF-8, v2 refuses to marshal it
+		return &skipValError{err: &PathError{Err: &json.UnsupportedValueError{Value: in, Str: strconv.Quote(in.String())}}}
+	}
+	if inType == outType {
+		out.Set(in)
+		return nil
+	}
+	switch in.Kind() {
+	case reflect.Bool:
+		if out.Kind() != reflect.Bool {
+			return &json.UnmarshalTypeError{Value: "bool", Type: outType}
+		}
+		out.SetBool(in.Bool())
+		return nil
+	case reflect.String:
+		return stringToScalar(in.String(), out, outType, options)
+	}
+	return numberToScalar(in, inType, out, outType)
+}
+
+// unsupportedFloatError returns the error for a NaN or infinity, which json.Marshal rejects.
+func unsupportedFloatError(v reflect.Value) error {
+	return &skipValError{err: &PathError{Err: &json.UnsupportedValueError{Value: v, Str: strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())}}}
+}
+
+func stringToScalar(str string, out reflect.Value, outType reflect.Type, options Options) error {
+	switch out.Kind() {
+	case reflect.String:
+		out.SetString(str)
+		return nil
+	case reflect.Bool:
+		if options.Semantics == SemanticsV2 {
+			break
+		}
+		// not what json.Unmarshal does, but goloose has always accepted these
+		switch strings.ToLower(str) {
+		case "true":
+			out.SetBool(true)
+			return nil
+		case "false":
+			out.SetBool(false)
+			return nil
+		}
+	case reflect.Float64:
+		if options.StringToFloat64 {
+			if f, err := strconv.ParseFloat(str, 64); err == nil {
+				out.SetFloat(f)
+				return nil
+			}
+		}
+	case reflect.Slice:
+		if outType.Elem().Kind() == reflect.Uint8 {
+			// json.Unmarshal decodes strings into byte slices as base64
+			b, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return err
+			}
+			out.SetBytes(b)
+			return nil
+		}
+	case reflect.Array:
+		if isBinary(outType, options) {
+			// v2 decodes base64 into byte arrays, if it's the right length
+			b, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return err
+			}
+			if len(b) == out.Len() {
+				for i, c := range b {
+					out.Index(i).SetUint(uint64(c))
+				}
+				return nil
+			}
+		}
+	}
+	return &json.UnmarshalTypeError{Value: "string", Type: outType}
+}
+
+func numberToScalar(in reflect.Value, inType reflect.Type, out reflect.Value, outType reflect.Type) error {
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch in.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := in.Int()
+			if out.OverflowInt(n) {
+				return &json.UnmarshalTypeError{Value: "number " + strconv.FormatInt(n, 10), Type: outType}
+			}
+			out.SetInt(n)
+			return nil
+		case reflect.Float64:
+			// below 2^53 an integral float formats as exactly the integer it holds
+			if f := in.Float(); math.Abs(f) < 1<<53 && f == math.Trunc(f) && !out.OverflowInt(int64(f)) {
+				out.SetInt(int64(f))
+				return nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch in.Kind() {
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			n := in.Uint()
+			if out.OverflowUint(n) {
+				return &json.UnmarshalTypeError{Value: "number " + strconv.FormatUint(n, 10), Type: outType}
+			}
+			out.SetUint(n)
+			return nil
+		case reflect.Float64:
+			if f := in.Float(); f >= 0 && f < 1<<53 && f == math.Trunc(f) && !out.OverflowUint(uint64(f)) {
+				out.SetUint(uint64(f))
+				return nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		switch in.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if out.Kind() == reflect.Float32 {
+				out.SetFloat(float64(float32(in.Int())))
+			} else {
+				out.SetFloat(float64(in.Int()))
+			}
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if out.Kind() == reflect.Float32 {
+				out.SetFloat(float64(float32(in.Uint())))
+			} else {
+				out.SetFloat(float64(in.Uint()))
+			}
+			return nil
+		case reflect.Float64:
+			if out.Kind() == reflect.Float64 {
+				out.SetFloat(in.Float())
+				return nil
+			}
+		}
+	}
+	// everything else goes through the JSON text, so e.g. a float32 becomes its shortest
+	// decimal representation and large floats don't necessarily hold the integer they format as
+	return numberLiteralToScalar(numberLiteral(in, inType), out, outType)
+}
+
+// numberLiteral returns the JSON text json.Marshal produces for the number in.
+func numberLiteral(in reflect.Value, inType reflect.Type) string {
+	switch in.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(in.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(in.Uint(), 10)
+	case reflect.String:
+		// a json.Number
+		if in.String() == "" {
+			return "0"
+		}
+		return in.String()
+	}
+	return formatFloat(in.Float(), inType.Bits())
+}
+
+// numberLiteralToScalar sets out from the JSON number s, the way json.Unmarshal does.
+func numberLiteralToScalar(s string, out reflect.Value, outType reflect.Type) error {
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || out.OverflowInt(n) {
+			return &json.UnmarshalTypeError{Value: "number " + s, Type: outType}
+		}
+		out.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || out.OverflowUint(n) {
+			return &json.UnmarshalTypeError{Value: "number " + s, Type: outType}
+		}
+		out.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, outType.Bits())
+		if err != nil || out.OverflowFloat(n) {
+			return &json.UnmarshalTypeError{Value: "number " + s, Type: outType}
+		}
+		out.SetFloat(n)
+	case reflect.String:
+		if outType != numberType {
+			return &json.UnmarshalTypeError{Value: "number", Type: outType}
+		}
+		out.SetString(s)
+	default:
+		return &json.UnmarshalTypeError{Value: "number", Type: outType}
+	}
+	return nil
+}
+
+// quote returns the string that json.Marshal encodes v as for the ",string" option. Nil pointers and
+// floats JSON can't represent are returned as they are, since those aren't encoded as strings.
+func quote(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v
+		}
+		v = v.Elem()
+	}
+	var s string
+	switch v.Kind() {
+	case reflect.Bool:
+		s = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return v
+		}
+		s = formatFloat(f, v.Type().Bits())
+	case reflect.String:
+		b, _ := json.Marshal(v.String())
+		s = string(b)
+	default:
+		return v
+	}
+	return reflect.ValueOf(s)
+}
+
+// formatFloat formats f the same way json.Marshal does.
+func formatFloat(f float64, bits int) string {
+	abs := math.Abs(f)
+	fmt := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			fmt = 'e'
+		}
+	}
+	b := strconv.AppendFloat(nil, f, fmt, -1, bits)
+	if fmt == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return string(b)
+}
+
+// isValidNumber reports whether s is a valid JSON number literal.
+// This is the same check json.Marshal does for a json.Number, and is copied from
+// encoding/json (see the license notice in field.go).
+func isValidNumber(s string) bool {
+	// This function implements the JSON numbers grammar.
+	// See https://tools.ietf.org/html/rfc7159#section-6
+	// and https://www.json.org/img/number.png
+
+	if s == "" {
+		return false
+	}
+
+	// Optional -
+	if s[0] == '-' {
+		s = s[1:]
+		if s == "" {
+			return false
+		}
+	}
+
+	// Digits
+	switch {
+	default:
+		return false
+
+	case s[0] == '0':
+		s = s[1:]
+
+	case '1' <= s[0] && s[0] <= '9':
+		s = s[1:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	// . followed by 1 or more digits.
+	if len(s) >= 2 && s[0] == '.' && '0' <= s[1] && s[1] <= '9' {
+		s = s[2:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	// e or E followed by an optional - or + and
+	// 1 or more digits.
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+
+	// Make sure we are at the end.
+	return s == ""
+}
@@ -0,0 +1,220 @@
+package goloose
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Semantics selects which version of encoding/json goloose imitates.
+type Semantics int
+
+const (
+	// SemanticsV1 converts the way encoding/json does. This is the default.
+	SemanticsV1 Semantics = iota
+	// SemanticsV2 converts the way encoding/json/v2 does with its default options:
+	// field names are matched case-sensitively, nil slices and maps become empty ones,
+	// null clears any value, byte arrays are base64 strings, and omitempty omits
+	// values that would be encoded as null, "", {} or [].
+	// Values goloose can't convert natively under these rules, like structs using the
+	// embed, format or case tag options, are converted with encoding/json/v2 itself.
+	// That only reads json tags, so structs are always converted natively with other Options.TagNames
+	// or an Options.NameMapper.
+	// Errors are still reported as encoding/json errors.
+	SemanticsV2
+)
+
+// errJSONv2Unavailable is returned for SemanticsV2 when encoding/json/v2 isn't part of the build.
+var errJSONv2Unavailable = errors.New("goloose: SemanticsV2 requires encoding/json/v2, which needs Go 1.27 or later built with GOEXPERIMENT=jsonv2")
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+var jsonv2TypeCache sync.Map // map[reflect.Type]bool
+
+// needsJSONv2 reports whether values of type t have to be converted by encoding/json/v2
+// under SemanticsV2, because goloose doesn't implement how v2 treats them natively.
+// This includes types that v2 refuses to convert, so that we report an error too.
+func needsJSONv2(t reflect.Type, options Options) bool {
+	if implementsJSONv2Methods(t) || implementsJSONv2Methods(reflect.PointerTo(t)) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func:
+		return true
+	case reflect.Struct:
+		if !namesFieldsLikeJSON(options) {
+			// v2 would name the fields differently, so convert these natively
+			return false
+		}
+		if needs, ok := jsonv2TypeCache.Load(t); ok {
+			return needs.(bool)
+		}
+		// store a provisional answer first in case t refers to itself through an embedded pointer
+		jsonv2TypeCache.Store(t, false)
+		needs := structNeedsJSONv2(t)
+		jsonv2TypeCache.Store(t, needs)
+		return needs
+	}
+	return t == durationType
+}
+
+// structNeedsJSONv2 reports whether t's fields use anything that needsJSONv2 should hand off.
+func structNeedsJSONv2(t reflect.Type) bool {
+	hasTag := false
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("json")
+		hasTag = hasTag || ok
+		if tag == "-" {
+			continue
+		}
+		if !sf.IsExported() && !sf.Anonymous {
+			if ok {
+				// v2 rejects tags on unexported fields
+				return true
+			}
+			continue
+		}
+		name, opts := parseTag(tag)
+		if name != "" && !isValidTag(name) {
+			// v1 ignores names like these, v2 uses them
+			return true
+		}
+		ft := sf.Type
+		if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		for _, opt := range strings.Split(string(opts), ",") {
+			switch opt {
+			case "", "omitempty", "omitzero":
+			case "string":
+				switch ft.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+					reflect.Float32, reflect.Float64:
+				default:
+					// v2 only allows this on numbers
+					return true
+				}
+			default:
+				// embed, format, case and anything v2 rejects
+				return true
+			}
+		}
+		if sf.Anonymous && name == "" {
+			if ft.Kind() != reflect.Struct {
+				if sf.IsExported() {
+					// v2 requires these to have a name
+					return true
+				}
+				continue
+			}
+			if implementsJSONMethods(ft) || needsJSONv2(ft, Options{}) {
+				return true
+			}
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if names[name] {
+			// v2 rejects fields in the same struct with the same name
+			return true
+		}
+		names[name] = true
+	}
+	// v2 rejects structs with fields that can't be converted, unless they're tagged
+	return t.NumField() > 0 && !hasTag && len(cachedTypeFields(t, Options{}).list) == 0
+}
+
+// implementsJSONMethods reports whether t or *t has any of the methods encoding/json calls.
+func implementsJSONMethods(t reflect.Type) bool {
+	for _, t := range []reflect.Type{t, reflect.PointerTo(t)} {
+		if t.Implements(jsonMarshalerType) || t.Implements(jsonUnmarshalerType) ||
+			t.Implements(textMarshalerType) || t.Implements(textUnmarshalerType) ||
+			implementsJSONv2Methods(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyValueV2 reports whether v would be encoded as a JSON null, empty string,
+// empty object or empty array, which is what omitempty checks for in encoding/json/v2.
+func isEmptyValueV2(v reflect.Value, options Options) bool {
+	for {
+		if isNil(v) {
+			// v2 encodes nil maps and slices as {} and []
+			return true
+		}
+		if v.Kind() == reflect.Struct && !implementsJSONMethods(v.Type()) && !namesFieldsLikeJSON(options) {
+			return structIsEmptyV2(v, options)
+		}
+		if implementsJSONMethods(v.Type()) || v.Kind() == reflect.Struct {
+			b, err := marshalJSON(v, options)
+			if err != nil {
+				return false
+			}
+			switch string(b) {
+			case "null", `""`, "{}", "[]":
+				return true
+			}
+			return false
+		}
+		if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface {
+			break
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// structIsEmptyV2 reports whether every field of v is omitted, so that it's encoded as {}.
+// Marshaling v would name its fields differently, so this checks the fields the same way toStructImpl does.
+func structIsEmptyV2(v reflect.Value, options Options) bool {
+	for _, field := range cachedTypeFields(v.Type(), options).list {
+		val, ok := fieldByIndexNoAlloc(v, field.index)
+		if !ok || field.omitEmpty && isEmptyValueV2(val, options) {
+			continue
+		}
+		if field.omitZero && (field.isZero == nil && val.IsZero() || field.isZero != nil && field.isZero(val)) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// namesFieldsLikeJSON reports whether options name struct fields the way encoding/json does,
+// by reading their json tags and otherwise using their Go names.
+func namesFieldsLikeJSON(options Options) bool {
+	return options.NameMapper == nil && (len(options.TagNames) == 0 || len(options.TagNames) == 1 && options.TagNames[0] == "json")
+}
+
+// isBinary reports whether values of type t are encoded as base64 strings.
+// encoding/json/v2 does this for byte arrays as well as byte slices.
+func isBinary(t reflect.Type, options Options) bool {
+	if options.Semantics == SemanticsV2 && t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8 {
+		return !implementsJSONMethods(t.Elem())
+	}
+	return isByteSlice(t)
+}
+
+// binaryBytes returns the bytes held by a byte slice or array.
+func binaryBytes(v reflect.Value) []byte {
+	if v.Kind() == reflect.Array {
+		b := make([]byte, v.Len())
+		for i := range b {
+			b[i] = byte(v.Index(i).Uint())
+		}
+		return b
+	}
+	return v.Bytes()
+}
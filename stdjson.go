@@ -0,0 +1,11 @@
+//go:build !goexperiment.jsonv2
+
+package goloose
+
+// interfaceMapKeys reports whether encoding/json accepts maps keyed by interface types.
+// The original encoding/json implementation rejects them outright.
+const interfaceMapKeys = false
+
+// textMarshalerStringKeys reports whether encoding/json calls MarshalText for map keys of string types.
+// The original implementation uses them as they are.
+const textMarshalerStringKeys = false
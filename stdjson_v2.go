@@ -0,0 +1,12 @@
+//go:build goexperiment.jsonv2
+
+package goloose
+
+// interfaceMapKeys reports whether encoding/json accepts maps keyed by interface types.
+// When encoding/json is implemented on top of encoding/json/v2 it uses the dynamic type
+// of each key when marshaling, and unmarshals keys into empty interfaces as strings.
+const interfaceMapKeys = true
+
+// textMarshalerStringKeys reports whether encoding/json calls MarshalText for map keys of string types.
+// When encoding/json is implemented on top of encoding/json/v2 it does, like it does for any other key.
+const textMarshalerStringKeys = true